@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Actions for sources rejected by the access list
+const (
+	DenyActionDrop = "drop" // Silently ignore the request
+	DenyActionDeny = "deny" // Answer with a DENY Kiss-o'-Death
+	DenyActionRstr = "rstr" // Answer with a RSTR Kiss-o'-Death
+)
+
+// AccessList enforces CIDR-based allow and deny lists for IPv4 and IPv6.
+// Deny entries take precedence; an empty allow list allows everything.
+type AccessList struct {
+	allow  []*net.IPNet
+	deny   []*net.IPNet
+	action string
+}
+
+// NewAccessList builds the access list from the security configuration
+func NewAccessList(config *Config) (*AccessList, error) {
+	acl := &AccessList{
+		action: strings.ToLower(config.Security.DenyAction),
+	}
+
+	switch acl.action {
+	case DenyActionDrop, DenyActionDeny, DenyActionRstr:
+	default:
+		return nil, fmt.Errorf("invalid deny_action %q (must be %s, %s or %s)",
+			config.Security.DenyAction, DenyActionDrop, DenyActionDeny, DenyActionRstr)
+	}
+
+	for _, entry := range config.Security.AllowList {
+		network, err := ParseNetwork(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allow_list entry: %w", err)
+		}
+		acl.allow = append(acl.allow, network)
+	}
+
+	for _, entry := range config.Security.DenyList {
+		network, err := ParseNetwork(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny_list entry: %w", err)
+		}
+		acl.deny = append(acl.deny, network)
+	}
+
+	return acl, nil
+}
+
+// Allowed reports whether a source address may use the server
+func (a *AccessList) Allowed(ip net.IP) bool {
+	for _, network := range a.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(a.allow) == 0 {
+		return true
+	}
+
+	for _, network := range a.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DenyCode returns the kiss code to send rejected sources, or "" to drop
+func (a *AccessList) DenyCode() string {
+	switch a.action {
+	case DenyActionDeny:
+		return "DENY"
+	case DenyActionRstr:
+		return "RSTR"
+	}
+	return ""
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminServer is the optional HTTP API for inspecting and steering a
+// running daemon
+type AdminServer struct {
+	config     *Config
+	server     *NTPServer
+	httpServer *http.Server
+}
+
+// controlRequest is the body of a client control call
+type controlRequest struct {
+	Client  string  `json:"client,omitempty"` // Exact client key
+	CIDR    string  `json:"cidr,omitempty"`   // Or every client in a network
+	Action  string  `json:"action"`
+	Seconds float64 `json:"seconds,omitempty"` // Offset or step size
+}
+
+// manipulationPatch holds global manipulation changes; nil fields are kept
+type manipulationPatch struct {
+	InitialOffsetMinutes *int     `json:"initial_offset_minutes"`
+	JitterSeconds        *int     `json:"jitter_seconds"`
+	Distribution         *string  `json:"distribution"`
+	DriftEnabled         *bool    `json:"drift_enabled"`
+	DriftMinPPM          *float64 `json:"drift_min_ppm"`
+	DriftMaxPPM          *float64 `json:"drift_max_ppm"`
+	DriftWanderPPM       *float64 `json:"drift_wander_ppm"`
+}
+
+// manipulationView is the JSON form of the global manipulation settings
+type manipulationView struct {
+	InitialOffsetMinutes int     `json:"initial_offset_minutes"`
+	JitterSeconds        int     `json:"jitter_seconds"`
+	Distribution         string  `json:"distribution"`
+	DriftEnabled         bool    `json:"drift_enabled"`
+	DriftMinPPM          float64 `json:"drift_min_ppm"`
+	DriftMaxPPM          float64 `json:"drift_max_ppm"`
+	DriftWanderPPM       float64 `json:"drift_wander_ppm"`
+	Scenario             string  `json:"scenario,omitempty"`
+}
+
+// NewAdminServer creates the admin API server
+func NewAdminServer(config *Config, server *NTPServer) *AdminServer {
+	admin := &AdminServer{
+		config: config,
+		server: server,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/clients", admin.handleClients)
+	mux.HandleFunc("/api/clients/", admin.handleClient)
+	mux.HandleFunc("/api/control", admin.handleControl)
+	mux.HandleFunc("/api/manipulation", admin.handleManipulation)
+	mux.HandleFunc("/api/stats", admin.handleStats)
+	mux.HandleFunc("/api/peers", admin.handlePeers)
+
+	admin.httpServer = &http.Server{
+		Addr:              net.JoinHostPort(config.Admin.Host, strconv.Itoa(config.Admin.Port)),
+		Handler:           admin.authenticate(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return admin
+}
+
+// Start serves the admin API until Stop is called
+func (a *AdminServer) Start() error {
+	LogInfo("Admin API listening on %s", a.httpServer.Addr)
+
+	err := a.httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Stop shuts down the admin API
+func (a *AdminServer) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return a.httpServer.Shutdown(ctx)
+}
+
+// authenticate requires the configured bearer token, if any
+func (a *AdminServer) authenticate(next http.Handler) http.Handler {
+	token := a.config.Admin.Token
+	if token == "" {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleClients lists all tracked clients
+func (a *AdminServer) handleClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.server.tracker.Clients())
+}
+
+// handleClient serves /api/clients/{key} and /api/clients/{key}/{action}.
+// Keys containing '/' (subnet keys) must be URL-escaped.
+func (a *AdminServer) handleClient(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/api/clients/"), "/")
+	key, err := url.PathUnescape(parts[0])
+	if err != nil || key == "" || len(parts) > 2 {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		snapshot, ok := a.server.tracker.Client(key)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("client %q is not tracked", key))
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshot)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	req := controlRequest{Client: key, Action: parts[1]}
+	if r.ContentLength != 0 {
+		var body controlRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+		req.Seconds = body.Seconds
+	}
+
+	a.control(w, req)
+}
+
+// handleControl applies an action to a client or every client in a CIDR
+func (a *AdminServer) handleControl(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+
+	var req controlRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return
+	}
+
+	a.control(w, req)
+}
+
+// control runs a validated control request against the tracker
+func (a *AdminServer) control(w http.ResponseWriter, req controlRequest) {
+	var match func(key string, address net.IP) bool
+	seedKey := ""
+
+	switch {
+	case req.Client != "" && req.CIDR != "":
+		writeError(w, http.StatusBadRequest, "specify either client or cidr, not both")
+		return
+	case req.Client != "":
+		seedKey = req.Client
+		match = func(key string, _ net.IP) bool { return key == req.Client }
+	case req.CIDR != "":
+		network, err := ParseNetwork(req.CIDR)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		match = func(key string, address net.IP) bool {
+			if address == nil {
+				// States saved before addresses were recorded were keyed by IP
+				address = net.ParseIP(key)
+			}
+			return address != nil && network.Contains(address)
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "client or cidr is required")
+		return
+	}
+
+	changed, err := a.server.tracker.ControlClients(match, seedKey, req.Action, req.Seconds)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	LogInfo("Admin: %s (%g s) applied to %d clients", req.Action, req.Seconds, changed)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"action":  req.Action,
+		"changed": changed,
+	})
+}
+
+// handleManipulation shows or changes the global manipulation parameters
+func (a *AdminServer) handleManipulation(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, newManipulationView(a.server.tracker.Config()))
+
+	case http.MethodPatch, http.MethodPut, http.MethodPost:
+		var patch manipulationPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+			return
+		}
+
+		// Hold the server's config lock so a concurrent reload cannot
+		// undo this update, or be undone by it
+		a.server.configMu.Lock()
+		defer a.server.configMu.Unlock()
+
+		// Copy-on-write: the tracker keeps using the old config until the
+		// new one validates. Validation normalizes scenario phases in
+		// place, so the slices are copied too.
+		updated := *a.server.tracker.Config()
+		updated.Profiles = append([]ManipulationProfile(nil), updated.Profiles...)
+		scenario := &updated.TimeManipulation.Scenario
+		scenario.Phases = append([]ScenarioPhase(nil), scenario.Phases...)
+		patch.apply(&updated)
+		if err := updated.ValidateManipulation(); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		a.server.tracker.UpdateConfig(&updated)
+		LogInfo("Admin: manipulation parameters updated (N=%d min, X=%d sec, distribution=%s)",
+			updated.TimeManipulation.InitialOffsetMinutes,
+			updated.TimeManipulation.JitterSeconds,
+			updated.TimeManipulation.Distribution)
+		writeJSON(w, http.StatusOK, newManipulationView(&updated))
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "use GET or PATCH")
+	}
+}
+
+// handleStats reports server counters
+func (a *AdminServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	clients, requests := a.server.tracker.GetStats()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"clients":    clients,
+		"requests":   requests,
+		"pipeline":   a.server.pipeline.stats(),
+		"rate_limit": a.server.policies.Load().limiter.Stats(),
+	})
+}
+
+// handlePeers lists symmetric peer associations
+func (a *AdminServer) handlePeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.server.peers.Peers())
+}
+
+// apply copies the set fields of the patch into the configuration
+func (p *manipulationPatch) apply(config *Config) {
+	tm := &config.TimeManipulation
+	if p.InitialOffsetMinutes != nil {
+		tm.InitialOffsetMinutes = *p.InitialOffsetMinutes
+	}
+	if p.JitterSeconds != nil {
+		tm.JitterSeconds = *p.JitterSeconds
+	}
+	if p.Distribution != nil {
+		tm.Distribution = *p.Distribution
+	}
+	if p.DriftEnabled != nil {
+		tm.Drift.Enabled = *p.DriftEnabled
+	}
+	if p.DriftMinPPM != nil {
+		tm.Drift.MinPPM = *p.DriftMinPPM
+	}
+	if p.DriftMaxPPM != nil {
+		tm.Drift.MaxPPM = *p.DriftMaxPPM
+	}
+	if p.DriftWanderPPM != nil {
+		tm.Drift.WanderPPM = *p.DriftWanderPPM
+	}
+}
+
+// newManipulationView extracts the global manipulation settings
+func newManipulationView(config *Config) manipulationView {
+	tm := config.TimeManipulation
+	return manipulationView{
+		InitialOffsetMinutes: tm.InitialOffsetMinutes,
+		JitterSeconds:        tm.JitterSeconds,
+		Distribution:         tm.Distribution,
+		DriftEnabled:         tm.Drift.Enabled,
+		DriftMinPPM:          tm.Drift.MinPPM,
+		DriftMaxPPM:          tm.Drift.MaxPPM,
+		DriftWanderPPM:       tm.Drift.WanderPPM,
+		Scenario:             tm.Scenario.Name,
+	}
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
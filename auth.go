@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Symmetric key types
+const (
+	KeyTypeMD5  = "MD5"
+	KeyTypeSHA1 = "SHA1"
+	KeyTypeCMAC = "AES128CMAC" // RFC 8573
+)
+
+// Outcomes of signing a response, reported in transaction logs
+const (
+	AuthSigned    = "signed"
+	AuthCryptoNAK = "crypto_nak"
+	AuthBadMAC    = "bad_mac"
+	AuthWrongKey  = "wrong_key"
+)
+
+// maxKeyID is the largest symmetric key ID ntpd accepts
+const maxKeyID = 65535
+
+// SymmetricKey is one entry of an ntpd-style keys file
+type SymmetricKey struct {
+	ID     uint32
+	Type   string
+	secret []byte
+	block  cipher.Block // AES-CMAC only
+}
+
+// Digest computes the MAC digest of message under the key
+func (k *SymmetricKey) Digest(message []byte) []byte {
+	switch k.Type {
+	case KeyTypeMD5:
+		h := md5.New()
+		h.Write(k.secret)
+		h.Write(message)
+		return h.Sum(nil)
+	case KeyTypeSHA1:
+		h := sha1.New()
+		h.Write(k.secret)
+		h.Write(message)
+		return h.Sum(nil)
+	}
+	return cmac(k.block, message)
+}
+
+// LoadKeys reads an ntpd-style keys file. Each line holds a key ID, a type
+// (MD5, SHA1 or AES128CMAC) and a key. Keys of up to 20 characters are
+// ASCII, longer ones hex; HEX: and ASCII: prefixes force an encoding.
+func LoadKeys(path string) (map[uint32]*SymmetricKey, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keys file: %w", err)
+	}
+	defer file.Close()
+
+	keys := make(map[uint32]*SymmetricKey)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("keys file line %d: expected \"id type key\"", lineNum)
+		}
+
+		key, err := parseKey(fields[0], fields[1], fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("keys file line %d: %w", lineNum, err)
+		}
+		if _, exists := keys[key.ID]; exists {
+			return nil, fmt.Errorf("keys file line %d: duplicate key ID %d", lineNum, key.ID)
+		}
+		keys[key.ID] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading keys file: %w", err)
+	}
+
+	return keys, nil
+}
+
+// parseKey parses the fields of one keys file entry
+func parseKey(idField, typeField, keyField string) (*SymmetricKey, error) {
+	id, err := strconv.ParseUint(idField, 10, 32)
+	if err != nil || id == 0 || id > maxKeyID {
+		return nil, fmt.Errorf("invalid key ID %q (must be 1-%d)", idField, maxKeyID)
+	}
+
+	key := &SymmetricKey{ID: uint32(id)}
+	switch strings.ToUpper(typeField) {
+	case "M", "MD5":
+		key.Type = KeyTypeMD5
+	case "SHA1", "SHA-1":
+		key.Type = KeyTypeSHA1
+	case "AES128CMAC", "AES-128-CMAC", "AES128", "CMAC":
+		key.Type = KeyTypeCMAC
+	default:
+		return nil, fmt.Errorf("unsupported key type %q (must be MD5, SHA1 or AES128CMAC)", typeField)
+	}
+
+	switch {
+	case strings.HasPrefix(keyField, "HEX:"):
+		key.secret, err = hex.DecodeString(keyField[4:])
+	case strings.HasPrefix(keyField, "ASCII:"):
+		key.secret = []byte(keyField[6:])
+	case len(keyField) > 20:
+		key.secret, err = hex.DecodeString(keyField)
+	default:
+		key.secret = []byte(keyField)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex key for ID %d: %w", key.ID, err)
+	}
+	if len(key.secret) == 0 {
+		return nil, fmt.Errorf("empty key for ID %d", key.ID)
+	}
+
+	if key.Type == KeyTypeCMAC {
+		if len(key.secret) != 16 {
+			return nil, fmt.Errorf("AES-128-CMAC key %d must be 16 bytes (got %d)", key.ID, len(key.secret))
+		}
+		if key.block, err = aes.NewCipher(key.secret); err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// authResult is the outcome of verifying a request's MAC
+type authResult struct {
+	status int
+	keyID  uint32
+	reason string // why verification failed
+}
+
+// Request authentication states
+const (
+	authUnsigned = iota // No MAC, or authentication disabled
+	authValid
+	authInvalid
+)
+
+// Authenticator verifies request MACs and signs responses with the same
+// key, optionally corrupting the signature to exercise client failure paths
+type Authenticator struct {
+	enabled  bool
+	required bool
+	keys     map[uint32]*SymmetricKey
+	trusted  map[uint32]bool // nil = every key in the file
+
+	badMAC    float64
+	wrongKey  float64
+	cryptoNAK float64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewAuthenticator loads the keys file and builds the authentication policy
+func NewAuthenticator(config *Config) (*Authenticator, error) {
+	ac := config.Authentication
+	auth := &Authenticator{
+		enabled:   ac.Enabled,
+		required:  ac.Require,
+		badMAC:    ac.Chaos.BadMACFraction,
+		wrongKey:  ac.Chaos.WrongKeyFraction,
+		cryptoNAK: ac.Chaos.CryptoNAKFraction,
+		rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if !auth.enabled {
+		return auth, nil
+	}
+
+	for _, fraction := range []float64{auth.badMAC, auth.wrongKey, auth.cryptoNAK} {
+		if fraction < 0 || fraction > 1 {
+			return nil, fmt.Errorf("invalid authentication chaos fraction: %g (must be 0-1)", fraction)
+		}
+	}
+	if sum := auth.badMAC + auth.wrongKey + auth.cryptoNAK; sum > 1 {
+		return nil, fmt.Errorf("invalid authentication chaos fractions: sum is %g (must be at most 1)", sum)
+	}
+
+	if ac.KeysFile == "" {
+		return nil, fmt.Errorf("authentication is enabled but no keys_file is set")
+	}
+	keys, err := LoadKeys(ac.KeysFile)
+	if err != nil {
+		return nil, err
+	}
+	auth.keys = keys
+
+	if len(ac.TrustedKeys) > 0 {
+		auth.trusted = make(map[uint32]bool)
+		for _, id := range ac.TrustedKeys {
+			if _, ok := keys[id]; !ok {
+				return nil, fmt.Errorf("trusted key %d is not in %s", id, ac.KeysFile)
+			}
+			auth.trusted[id] = true
+		}
+	}
+
+	return auth, nil
+}
+
+// Required reports whether unauthenticated requests must be dropped
+func (a *Authenticator) Required() bool {
+	return a.enabled && a.required
+}
+
+// HasKey reports whether authentication is enabled with the given key
+func (a *Authenticator) HasKey(id uint32) bool {
+	return a.enabled && a.keys[id] != nil
+}
+
+// Verify checks the MAC of a request. data is the request as received.
+func (a *Authenticator) Verify(data []byte, request *NTPPacket) authResult {
+	if !a.enabled || request.MAC == nil {
+		return authResult{status: authUnsigned}
+	}
+
+	mac := request.MAC
+	key, ok := a.keys[mac.KeyID]
+	if !ok || (a.trusted != nil && !a.trusted[mac.KeyID]) {
+		return authResult{status: authInvalid, keyID: mac.KeyID, reason: "untrusted_key"}
+	}
+
+	message := data[:len(data)-mac.Length()]
+	if subtle.ConstantTimeCompare(key.Digest(message), mac.Digest) != 1 {
+		return authResult{status: authInvalid, keyID: mac.KeyID, reason: "bad_mac"}
+	}
+
+	return authResult{status: authValid, keyID: mac.KeyID}
+}
+
+// Sign appends the response MAC according to the request's verification
+// result and returns what was sent. Responses to failed requests carry a
+// crypto-NAK; valid requests are signed with the same key unless chaos
+// picks a corrupted signature. Call Sign after the last header change.
+func (a *Authenticator) Sign(response *NTPPacket, result authResult) string {
+	switch result.status {
+	case authUnsigned:
+		return ""
+	case authInvalid:
+		response.MAC = &MAC{}
+		return AuthCryptoNAK
+	}
+
+	a.mu.Lock()
+	roll := a.rand.Float64()
+	a.mu.Unlock()
+
+	if roll < a.cryptoNAK {
+		response.MAC = &MAC{}
+		return AuthCryptoNAK
+	}
+
+	key := a.keys[result.keyID]
+	response.MAC = nil
+	mac := &MAC{KeyID: key.ID, Digest: key.Digest(response.ToBytes())}
+	response.MAC = mac
+
+	switch roll -= a.cryptoNAK; {
+	case roll < a.badMAC:
+		mac.Digest[len(mac.Digest)-1] ^= 0xff
+		return AuthBadMAC
+	case roll < a.badMAC+a.wrongKey:
+		mac.KeyID = key.ID%maxKeyID + 1
+		return AuthWrongKey
+	}
+	return AuthSigned
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  [3]string
+		keyType string
+		secret  string
+		wantErr bool
+	}{
+		{"md5 ascii", [3]string{"1", "M", "secret"}, KeyTypeMD5, "secret", false},
+		{"sha1 hex", [3]string{"2", "SHA1", "000102030405060708090a0b0c0d0e0f10111213"}, KeyTypeSHA1,
+			"\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f\x10\x11\x12\x13", false},
+		{"ascii prefix", [3]string{"3", "MD5", "ASCII:0123456789abcdef0123456789"}, KeyTypeMD5, "0123456789abcdef0123456789", false},
+		{"hex prefix", [3]string{"4", "MD5", "HEX:4142"}, KeyTypeMD5, "AB", false},
+		{"cmac", [3]string{"5", "AES128CMAC", "HEX:2b7e151628aed2a6abf7158809cf4f3c"}, KeyTypeCMAC,
+			"\x2b\x7e\x15\x16\x28\xae\xd2\xa6\xab\xf7\x15\x88\x09\xcf\x4f\x3c", false},
+		{"cmac wrong size", [3]string{"6", "CMAC", "short"}, "", "", true},
+		{"zero id", [3]string{"0", "MD5", "secret"}, "", "", true},
+		{"id too large", [3]string{"65536", "MD5", "secret"}, "", "", true},
+		{"unknown type", [3]string{"7", "SHA256", "secret"}, "", "", true},
+		{"bad hex", [3]string{"8", "MD5", "HEX:zz"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseKey(tt.fields[0], tt.fields[1], tt.fields[2])
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseKey(%q) succeeded, want error", tt.fields)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseKey(%q): %v", tt.fields, err)
+			}
+			if key.Type != tt.keyType || string(key.secret) != tt.secret {
+				t.Errorf("parseKey(%q) = %s %q, want %s %q", tt.fields, key.Type, key.secret, tt.keyType, tt.secret)
+			}
+		})
+	}
+}
+
+// newTestAuthenticator loads a keys file with one key of each type
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ntp.keys")
+	keys := "# id type key\n" +
+		"1 MD5 md5secret\n" +
+		"2 SHA1 sha1secret\n" +
+		"3 AES128CMAC 2b7e151628aed2a6abf7158809cf4f3c\n"
+	if err := os.WriteFile(path, []byte(keys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{}
+	config.Authentication.Enabled = true
+	config.Authentication.KeysFile = path
+	auth, err := NewAuthenticator(config)
+	if err != nil {
+		t.Fatalf("NewAuthenticator: %v", err)
+	}
+	return auth
+}
+
+// signedRequest builds a client request carrying a MAC under key
+func signedRequest(key *SymmetricKey) []byte {
+	data := (&NTPPacket{Version: 4, Mode: 3, TransmitTime: 0x0123456789abcdef}).ToBytes()
+	data = binary.BigEndian.AppendUint32(data, key.ID)
+	return append(data, key.Digest(data[:ntpPacketSize])...)
+}
+
+func TestAuthenticatorRoundTrip(t *testing.T) {
+	auth := newTestAuthenticator(t)
+
+	for _, id := range []uint32{1, 2, 3} {
+		key := auth.keys[id]
+		t.Run(key.Type, func(t *testing.T) {
+			data := signedRequest(key)
+			request, err := ParseNTPPacket(data, true)
+			if err != nil {
+				t.Fatalf("ParseNTPPacket: %v", err)
+			}
+			result := auth.Verify(data, request)
+			if result.status != authValid || result.keyID != id {
+				t.Fatalf("Verify = %+v, want valid key %d", result, id)
+			}
+
+			response := CreateResponse(request, &Config{}, NTPToUnix(request.TransmitTime))
+			if outcome := auth.Sign(response, result); outcome != AuthSigned {
+				t.Fatalf("Sign = %q, want %q", outcome, AuthSigned)
+			}
+			signed := response.ToBytes()
+			reply, err := ParseNTPPacket(signed, true)
+			if err != nil {
+				t.Fatalf("ParseNTPPacket(response): %v", err)
+			}
+			if got := auth.Verify(signed, reply); got.status != authValid {
+				t.Errorf("response does not verify: %+v", got)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorRejects(t *testing.T) {
+	auth := newTestAuthenticator(t)
+
+	tests := []struct {
+		name   string
+		mutate func([]byte) []byte
+		reason string
+	}{
+		{"corrupted digest", func(b []byte) []byte { b[len(b)-1] ^= 0xff; return b }, "bad_mac"},
+		{"modified header", func(b []byte) []byte { b[40] ^= 0x01; return b }, "bad_mac"},
+		{"unknown key", func(b []byte) []byte { binary.BigEndian.PutUint32(b[ntpPacketSize:], 99); return b }, "untrusted_key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.mutate(signedRequest(auth.keys[1]))
+			request, err := ParseNTPPacket(data, true)
+			if err != nil {
+				t.Fatalf("ParseNTPPacket: %v", err)
+			}
+			result := auth.Verify(data, request)
+			if result.status != authInvalid || result.reason != tt.reason {
+				t.Errorf("Verify = %+v, want invalid (%s)", result, tt.reason)
+			}
+
+			response := CreateResponse(request, &Config{}, NTPToUnix(request.TransmitTime))
+			if outcome := auth.Sign(response, result); outcome != AuthCryptoNAK || response.MAC.Length() != 4 {
+				t.Errorf("Sign = %q with %d byte MAC, want a crypto-NAK", outcome, response.MAC.Length())
+			}
+		})
+	}
+}
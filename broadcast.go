@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// broadcastKeyPrefix marks tracker entries that belong to broadcast targets
+const broadcastKeyPrefix = "broadcast/"
+
+// Broadcaster periodically sends unsolicited mode 5 packets carrying
+// manipulated time to broadcast and multicast groups (RFC 5905 broadcast
+// server mode). All clients of a group share one tracked timeline.
+type Broadcaster struct {
+	server   *NTPServer
+	targets  []*net.UDPAddr
+	interval time.Duration
+	poll     int8
+	version  uint8
+	keyID    uint32
+	ttl      int
+
+	conns map[string]*net.UDPConn // By network: udp4, udp6; owned by Start
+	done  chan struct{}
+	once  sync.Once
+}
+
+// NewBroadcaster creates the broadcast sender. Sockets are opened by Start.
+func NewBroadcaster(config *Config, server *NTPServer) (*Broadcaster, error) {
+	bc := config.Broadcast
+	targets, err := parseBroadcastTargets(config)
+	if err != nil {
+		return nil, err
+	}
+	if bc.IntervalSeconds < 1 || bc.IntervalSeconds > 131072 {
+		return nil, fmt.Errorf("invalid broadcast interval: %d (must be 1-131072 seconds)", bc.IntervalSeconds)
+	}
+	if bc.TTL < 1 || bc.TTL > 255 {
+		return nil, fmt.Errorf("invalid broadcast ttl: %d (must be 1-255)", bc.TTL)
+	}
+	if bc.Version < 1 || bc.Version > 4 {
+		return nil, fmt.Errorf("invalid broadcast version: %d (must be 1-4)", bc.Version)
+	}
+
+	return &Broadcaster{
+		server:   server,
+		targets:  targets,
+		interval: time.Duration(bc.IntervalSeconds) * time.Second,
+		poll:     int8(bits.Len(uint(bc.IntervalSeconds)) - 1), // log2, rounded down
+		version:  uint8(bc.Version),
+		keyID:    bc.KeyID,
+		ttl:      bc.TTL,
+		conns:    make(map[string]*net.UDPConn),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// parseBroadcastTargets resolves the configured targets. IPv4 targets may be
+// any address (usually a subnet broadcast or a multicast group); IPv6 has no
+// broadcast, so IPv6 targets must be multicast groups such as ff02::101.
+// Link-local groups take an interface zone, e.g. ff02::101%eth0.
+func parseBroadcastTargets(config *Config) ([]*net.UDPAddr, error) {
+	bc := config.Broadcast
+	if len(bc.Targets) == 0 {
+		return nil, fmt.Errorf("broadcast is enabled but no targets are set")
+	}
+	if bc.Port < 1 || bc.Port > 65535 {
+		return nil, fmt.Errorf("invalid broadcast port: %d (must be 1-65535)", bc.Port)
+	}
+
+	targets := make([]*net.UDPAddr, 0, len(bc.Targets))
+	for _, target := range bc.Targets {
+		addr, err := netip.ParseAddr(target)
+		if err != nil || addr.Is4In6() || (addr.Is6() && !addr.IsMulticast()) {
+			return nil, fmt.Errorf("invalid broadcast target: %q (must be an IPv4 address or an IPv6 multicast group)", target)
+		}
+		targets = append(targets, &net.UDPAddr{
+			IP:   addr.AsSlice(),
+			Port: bc.Port,
+			Zone: addr.Zone(),
+		})
+	}
+	return targets, nil
+}
+
+// Start sends broadcasts every interval until Stop is called
+func (b *Broadcaster) Start() error {
+	defer func() {
+		for _, conn := range b.conns {
+			conn.Close()
+		}
+	}()
+
+	for _, target := range b.targets {
+		network := "udp6"
+		if target.IP.To4() != nil {
+			network = "udp4"
+		}
+		if b.conns[network] != nil {
+			continue
+		}
+
+		conn, err := net.ListenUDP(network, nil)
+		if err != nil {
+			return fmt.Errorf("failed to open %s broadcast socket: %w", network, err)
+		}
+		b.conns[network] = conn
+		if err := setMulticastTTL(conn, network == "udp6", b.ttl); err != nil {
+			return fmt.Errorf("failed to set multicast ttl: %w", err)
+		}
+	}
+
+	LogInfo("Broadcasting to %d target(s) every %s", len(b.targets), b.interval)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		for _, target := range b.targets {
+			b.send(target)
+		}
+		select {
+		case <-ticker.C:
+		case <-b.done:
+			return nil
+		}
+	}
+}
+
+// Stop ends broadcasting
+func (b *Broadcaster) Stop() error {
+	b.once.Do(func() {
+		close(b.done)
+	})
+	return nil
+}
+
+// send transmits one broadcast packet to a target
+func (b *Broadcaster) send(target *net.UDPAddr) {
+	s := b.server
+	p := s.policies.Load()
+
+	now := time.Now()
+	manipulatedTime, offset, isInitial := s.tracker.GetManipulatedTime(broadcastKeyPrefix+target.String(), target.IP, now)
+
+	servedTime, leapIndicator := p.leap.Apply(manipulatedTime)
+	packet := CreateBroadcast(p.config, servedTime, b.version, b.poll)
+	packet.LeapIndicator = leapIndicator
+
+	// Stamp T3 as late as possible, shifted by the same offset
+	txTime := time.Now()
+	txServed, _ := p.leap.Apply(txTime.Add(manipulatedTime.Sub(now)))
+	packet.TransmitTime = UnixToNTP(txServed)
+
+	injected := p.extensions.Inject(packet)
+	var authOutcome string
+	if b.keyID != 0 {
+		authOutcome = p.auth.Sign(packet, authResult{status: authValid, keyID: b.keyID})
+	}
+
+	network := "udp6"
+	if target.IP.To4() != nil {
+		network = "udp4"
+	}
+	if _, err := b.conns[network].WriteToUDP(packet.ToBytes(), target); err != nil {
+		LogError("Error sending broadcast to %s: %v", target.String(), err)
+		s.metrics.RecordResponse(OutcomeBroadcast, "send_error")
+		return
+	}
+	s.metrics.RecordResponse(OutcomeBroadcast, "ok")
+
+	if p.config.Logging.LogTransactions {
+		b.logBroadcast(target, packet, offset, isInitial, manipulatedTime, authOutcome, injected)
+	}
+}
+
+// logBroadcast logs a sent broadcast packet
+func (b *Broadcaster) logBroadcast(target *net.UDPAddr, packet *NTPPacket, offset float64, isInitial bool,
+	manipulatedTime time.Time, authOutcome string, injected []string) {
+
+	log := TransactionLog{
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Event:       "ntp_broadcast",
+		RequestType: "broadcast",
+	}
+
+	log.Client.IP = target.IP.String()
+	log.Client.Port = target.Port
+	log.Client.IsNew = isInitial
+
+	log.Response.Stratum = int(packet.Stratum)
+	log.Response.ReferenceID = string(packet.ReferenceID[:])
+	log.Response.ActualTime = time.Now().UTC().Format(time.RFC3339Nano)
+	log.Response.OffsetSeconds = offset
+	log.Response.OffsetMinutes = offset / 60.0
+	log.Response.ManipulatedTime = manipulatedTime.UTC().Format(time.RFC3339Nano)
+	log.Response.Auth = authOutcome
+	if packet.MAC != nil {
+		log.Response.KeyID = packet.MAC.KeyID
+	}
+	log.Response.Extensions = injected
+
+	manipulation := b.server.tracker.Config().TimeManipulation
+	log.Config.NMinutes = manipulation.InitialOffsetMinutes
+	log.Config.XSeconds = manipulation.JitterSeconds
+	log.Config.Stratum = b.server.Config().NTP.Stratum
+
+	text := fmt.Sprintf("broadcast to %s - offset: %.1f sec (%.2f min)",
+		target.String(), log.Response.OffsetSeconds, log.Response.OffsetMinutes)
+	if authOutcome != "" {
+		text += fmt.Sprintf(" [auth: %s, key %d]", authOutcome, log.Response.KeyID)
+	}
+	b.server.writeTransaction(&log, text)
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// setMulticastTTL sets the TTL (IPv4) or hop limit (IPv6) of multicast
+// packets sent on conn
+func setMulticastTTL(conn *net.UDPConn, ipv6 bool, ttl int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	level, option := syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL
+	if ipv6 {
+		level, option = syscall.IPPROTO_IPV6, syscall.IPV6_MULTICAST_HOPS
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), level, option, ttl)
+	})
+	if err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("setsockopt: %w", sockErr)
+	}
+
+	return nil
+}
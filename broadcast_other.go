@@ -0,0 +1,16 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// setMulticastTTL only supports the default TTL of 1 outside Linux
+func setMulticastTTL(conn *net.UDPConn, ipv6 bool, ttl int) error {
+	if ttl != 1 {
+		return errors.New("setting the multicast ttl is not supported on this platform")
+	}
+	return nil
+}
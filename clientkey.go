@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Client key modes
+const (
+	KeyModeIP          = "ip"          // One timeline per source address
+	KeyModeIPPort      = "ip+port"     // One timeline per source address and port
+	KeyModeSubnet      = "subnet"      // One timeline per source subnet
+	KeyModeFingerprint = "fingerprint" // Source address plus request traits
+)
+
+// Request fields usable in fingerprints
+const (
+	FingerprintVersion   = "version"
+	FingerprintPoll      = "poll"
+	FingerprintPrecision = "precision"
+	FingerprintTxPattern = "tx_pattern"
+)
+
+// ClientKeyer derives the tracker key that identifies a client timeline
+type ClientKeyer struct {
+	mode   string
+	v4Mask net.IPMask
+	v6Mask net.IPMask
+	fields []string
+}
+
+// NewClientKeyer builds the client keyer from the tracking configuration
+func NewClientKeyer(config *Config) (*ClientKeyer, error) {
+	kc := config.TimeManipulation.ClientTracking.Key
+	keyer := &ClientKeyer{mode: strings.ToLower(kc.Mode)}
+
+	switch keyer.mode {
+	case KeyModeIP, KeyModeIPPort:
+	case KeyModeSubnet:
+		if kc.IPv4Prefix < 0 || kc.IPv4Prefix > 32 {
+			return nil, fmt.Errorf("invalid client key ipv4_prefix: %d (must be 0-32)", kc.IPv4Prefix)
+		}
+		if kc.IPv6Prefix < 0 || kc.IPv6Prefix > 128 {
+			return nil, fmt.Errorf("invalid client key ipv6_prefix: %d (must be 0-128)", kc.IPv6Prefix)
+		}
+		keyer.v4Mask = net.CIDRMask(kc.IPv4Prefix, 32)
+		keyer.v6Mask = net.CIDRMask(kc.IPv6Prefix, 128)
+	case KeyModeFingerprint:
+		for _, field := range kc.FingerprintFields {
+			field = strings.ToLower(field)
+			switch field {
+			case FingerprintVersion, FingerprintPoll, FingerprintPrecision, FingerprintTxPattern:
+				keyer.fields = append(keyer.fields, field)
+			default:
+				return nil, fmt.Errorf("invalid fingerprint field %q (must be %s, %s, %s or %s)", field,
+					FingerprintVersion, FingerprintPoll, FingerprintPrecision, FingerprintTxPattern)
+			}
+		}
+		if len(keyer.fields) == 0 {
+			return nil, fmt.Errorf("fingerprint client keys require at least one fingerprint field")
+		}
+	default:
+		return nil, fmt.Errorf("invalid client key mode %q (must be %s, %s, %s or %s)",
+			kc.Mode, KeyModeIP, KeyModeIPPort, KeyModeSubnet, KeyModeFingerprint)
+	}
+
+	return keyer, nil
+}
+
+// Key returns the tracker key for a request from addr
+func (k *ClientKeyer) Key(addr *net.UDPAddr, request *NTPPacket) string {
+	switch k.mode {
+	case KeyModeIPPort:
+		return net.JoinHostPort(addr.IP.String(), strconv.Itoa(addr.Port))
+
+	case KeyModeSubnet:
+		return k.subnet(addr.IP)
+
+	case KeyModeFingerprint:
+		return fmt.Sprintf("%s#%08x", addr.IP.String(), k.fingerprint(request))
+	}
+
+	return addr.IP.String()
+}
+
+// KeyForHost returns the tracker key for a host on the non-NTP front ends.
+// Their connections come from ephemeral ports and carry no NTP traits, so
+// only the address, or its subnet in subnet mode, is used; in ip and
+// subnet modes this matches the host's NTP key.
+func (k *ClientKeyer) KeyForHost(ip net.IP) string {
+	if k.mode == KeyModeSubnet {
+		return k.subnet(ip)
+	}
+	return ip.String()
+}
+
+// subnet returns the configured network containing ip
+func (k *ClientKeyer) subnet(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		network := net.IPNet{IP: v4.Mask(k.v4Mask), Mask: k.v4Mask}
+		return network.String()
+	}
+	network := net.IPNet{IP: ip.Mask(k.v6Mask), Mask: k.v6Mask}
+	return network.String()
+}
+
+// fingerprint hashes the configured request traits. The source address is
+// kept in the key so distinct NAT gateways never share a timeline.
+func (k *ClientKeyer) fingerprint(request *NTPPacket) uint32 {
+	h := fnv.New32a()
+	for _, field := range k.fields {
+		switch field {
+		case FingerprintVersion:
+			fmt.Fprintf(h, "v%d;", request.Version)
+		case FingerprintPoll:
+			fmt.Fprintf(h, "p%d;", request.Poll)
+		case FingerprintPrecision:
+			fmt.Fprintf(h, "r%d;", request.Precision)
+		case FingerprintTxPattern:
+			fmt.Fprintf(h, "t%s;", transmitPattern(request.TransmitTime))
+		}
+	}
+	return h.Sum32()
+}
+
+// transmitPattern classifies how a client fills its transmit timestamp:
+// zero (many SNTP clients), whole seconds or a fraction. Clients such as
+// chrony and ntpd randomise the low fraction bits, so finer resolution
+// classes would split one client across several keys.
+func transmitPattern(tx uint64) string {
+	switch {
+	case tx == 0:
+		return "zero"
+	case uint32(tx) == 0:
+		return "whole-seconds"
+	}
+	return "fraction"
+}
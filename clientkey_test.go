@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestTransmitPattern(t *testing.T) {
+	tests := []struct {
+		tx   uint64
+		want string
+	}{
+		{0, "zero"},
+		{0xe8a1b2c300000000, "whole-seconds"},
+		{0xe8a1b2c300000100, "fraction"},
+		{0xe8a1b2c312345678, "fraction"},
+	}
+	for _, tt := range tests {
+		if got := transmitPattern(tt.tx); got != tt.want {
+			t.Errorf("transmitPattern(%016x) = %q, want %q", tt.tx, got, tt.want)
+		}
+	}
+}
+
+// Clients that randomise the low fraction bits keep a single key
+func TestFingerprintStableAcrossFractions(t *testing.T) {
+	for _, fields := range [][]string{
+		{FingerprintVersion, FingerprintPrecision}, // Defaults
+		{FingerprintVersion, FingerprintPrecision, FingerprintTxPattern},
+	} {
+		config := &Config{}
+		config.TimeManipulation.ClientTracking.Key.Mode = KeyModeFingerprint
+		config.TimeManipulation.ClientTracking.Key.FingerprintFields = fields
+		keyer, err := NewClientKeyer(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 123}
+		request := &NTPPacket{Version: 4, Mode: 3, Precision: -25}
+		rng := rand.New(rand.NewSource(1))
+		want := ""
+		for i := 0; i < 4096; i++ {
+			fraction := rng.Uint32() &^ (1<<uint(rng.Intn(32)) - 1) // Vary trailing zero bits
+			if fraction == 0 {
+				fraction = 1
+			}
+			request.TransmitTime = uint64(0xe8a1b2c3)<<32 | uint64(fraction)
+			key := keyer.Key(addr, request)
+			if want == "" {
+				want = key
+			} else if key != want {
+				t.Fatalf("fields %v: fraction %08x gave key %s, want %s", fields, fraction, key, want)
+			}
+		}
+	}
+}
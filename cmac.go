@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+)
+
+// cmacRb is the constant used to derive CMAC subkeys for 128-bit blocks
+const cmacRb = 0x87
+
+// cmac computes the AES-CMAC of msg (RFC 4493) with a 128-bit block cipher
+func cmac(block cipher.Block, msg []byte) []byte {
+	const size = 16
+
+	var k1, k2 [size]byte
+	block.Encrypt(k1[:], k1[:])
+	k1 = cmacDouble(k1)
+	k2 = cmacDouble(k1)
+
+	n := (len(msg) + size - 1) / size
+	complete := n > 0 && len(msg)%size == 0
+	if n == 0 {
+		n = 1
+	}
+
+	// The final block is xored with K1 if complete, else padded and xored with K2
+	var last [size]byte
+	tail := msg[(n-1)*size:]
+	if complete {
+		subtle.XORBytes(last[:], tail, k1[:])
+	} else {
+		copy(last[:], tail)
+		last[len(tail)] = 0x80
+		subtle.XORBytes(last[:], last[:], k2[:])
+	}
+
+	var x [size]byte
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x[:], x[:], msg[i*size:(i+1)*size])
+		block.Encrypt(x[:], x[:])
+	}
+	subtle.XORBytes(x[:], x[:], last[:])
+	block.Encrypt(x[:], x[:])
+
+	return x[:]
+}
+
+// cmacDouble multiplies a block by x in GF(2^128)
+func cmacDouble(in [16]byte) [16]byte {
+	var out [16]byte
+	carry := in[0] >> 7
+	for i := 0; i < 15; i++ {
+		out[i] = in[i]<<1 | in[i+1]>>7
+	}
+	out[15] = in[15] << 1
+	out[15] ^= cmacRb * carry
+	return out
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+// mustHex decodes a hex test vector, ignoring spaces
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	clean := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != ' ' {
+			clean = append(clean, s[i])
+		}
+	}
+	b, err := hex.DecodeString(string(clean))
+	if err != nil {
+		t.Fatalf("bad test vector %q: %v", s, err)
+	}
+	return b
+}
+
+// RFC 4493 section 4 test vectors
+func TestCMAC(t *testing.T) {
+	block, err := aes.NewCipher(mustHex(t, "2b7e1516 28aed2a6 abf71588 09cf4f3c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := mustHex(t, "6bc1bee2 2e409f96 e93d7e11 7393172a ae2d8a57 1e03ac9c 9eb76fac 45af8e51"+
+		"30c81c46 a35ce411 e5fbc119 1a0a52ef f69f2445 df4f9b17 ad2b417b e66c3710")
+
+	tests := []struct {
+		name string
+		len  int
+		want string
+	}{
+		{"empty", 0, "bb1d6929 e9593728 7fa37d12 9b756746"},
+		{"one block", 16, "070a16b4 6b4d4144 f79bdd9d d04a287c"},
+		{"partial block", 40, "dfa66747 de9ae630 30ca3261 1497c827"},
+		{"four blocks", 64, "51f0bebf 7e3b9d92 fc497417 79363cfe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cmac(block, msg[:tt.len])
+			if want := mustHex(t, tt.want); string(got) != string(want) {
+				t.Errorf("cmac = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+// RFC 4493 section 4 subkey derivation
+func TestCMACSubkeys(t *testing.T) {
+	block, err := aes.NewCipher(mustHex(t, "2b7e1516 28aed2a6 abf71588 09cf4f3c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l [16]byte
+	block.Encrypt(l[:], l[:])
+
+	k1 := cmacDouble(l)
+	k2 := cmacDouble(k1)
+	if want := mustHex(t, "fbeed618 35713366 7c85e08f 7236a8de"); string(k1[:]) != string(want) {
+		t.Errorf("K1 = %x, want %x", k1, want)
+	}
+	if want := mustHex(t, "f7ddac30 6ae266cc f90bc11e e46d513b"); string(k2[:]) != string(want) {
+		t.Errorf("K2 = %x, want %x", k2, want)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -14,6 +16,10 @@ type Config struct {
 		Host string `yaml:"host"`
 		Port int    `yaml:"port"`
 		Name string `yaml:"name"`
+
+		Workers          int  `yaml:"workers"`
+		QueueDepth       int  `yaml:"queue_depth"`
+		KernelTimestamps bool `yaml:"kernel_timestamps"`
 	} `yaml:"server"`
 
 	NTP struct {
@@ -23,31 +29,239 @@ type Config struct {
 	} `yaml:"ntp"`
 
 	TimeManipulation struct {
-		InitialOffsetMinutes int    `yaml:"initial_offset_minutes"`
-		JitterSeconds        int    `yaml:"jitter_seconds"`
-		Distribution         string `yaml:"distribution"`
+		InitialOffsetMinutes int                `yaml:"initial_offset_minutes"`
+		JitterSeconds        int                `yaml:"jitter_seconds"`
+		Distribution         string             `yaml:"distribution"`
+		DistributionParams   DistributionParams `yaml:"distribution_params"`
+
+		Drift struct {
+			Enabled   bool    `yaml:"enabled"`
+			MinPPM    float64 `yaml:"min_ppm"`
+			MaxPPM    float64 `yaml:"max_ppm"`
+			WanderPPM float64 `yaml:"wander_ppm"`
+		} `yaml:"drift"`
+
+		ScenarioFile string   `yaml:"scenario_file"`
+		Scenario     Scenario `yaml:"scenario"`
 
 		ClientTracking struct {
 			CleanupIntervalSeconds int `yaml:"cleanup_interval_seconds"`
 			MaxClientAgeSeconds    int `yaml:"max_client_age_seconds"`
 			MaxTrackedClients      int `yaml:"max_tracked_clients"`
+			Key                    struct {
+				Mode              string   `yaml:"mode"`
+				IPv4Prefix        int      `yaml:"ipv4_prefix"`
+				IPv6Prefix        int      `yaml:"ipv6_prefix"`
+				FingerprintFields []string `yaml:"fingerprint_fields"`
+			} `yaml:"key"`
 		} `yaml:"client_tracking"`
 	} `yaml:"time_manipulation"`
 
+	Profiles []ManipulationProfile `yaml:"profiles"`
+
+	Leap struct {
+		Indicator int `yaml:"indicator"`
+		Event     struct {
+			Enabled            bool   `yaml:"enabled"`
+			Time               string `yaml:"time"`
+			Type               string `yaml:"type"`
+			Mode               string `yaml:"mode"`
+			AnnounceSeconds    int    `yaml:"announce_seconds"`
+			SmearWindowSeconds int    `yaml:"smear_window_seconds"`
+		} `yaml:"event"`
+	} `yaml:"leap"`
+
+	KoD struct {
+		Enabled  bool    `yaml:"enabled"`
+		Code     string  `yaml:"code"`
+		Fraction float64 `yaml:"fraction"`
+		Clients  []struct {
+			CIDR string `yaml:"cidr"`
+			Code string `yaml:"code"`
+		} `yaml:"clients"`
+	} `yaml:"kod"`
+
 	Logging struct {
-		Level           string   `yaml:"level"`
-		Format          string   `yaml:"format"`
-		LogTransactions bool     `yaml:"log_transactions"`
-		Output          string   `yaml:"output"`
+		Level            string `yaml:"level"`
+		Format           string `yaml:"format"`
+		LogTransactions  bool   `yaml:"log_transactions"`
+		Output           string `yaml:"output"`
+		File             string `yaml:"file"`
+		DiagnosticFormat string `yaml:"diagnostic_format"`
+
+		TransactionOutput string `yaml:"transaction_output"`
+		TransactionFile   string `yaml:"transaction_file"`
+
+		Rotation struct {
+			MaxSizeMB   int `yaml:"max_size_mb"`
+			MaxAgeHours int `yaml:"max_age_hours"`
+			MaxBackups  int `yaml:"max_backups"`
+		} `yaml:"rotation"`
 	} `yaml:"logging"`
 
 	Security struct {
-		AllowList []string `yaml:"allow_list"`
-		RateLimit struct {
-			Enabled              bool `yaml:"enabled"`
-			MaxRequestsPerMinute int  `yaml:"max_requests_per_minute"`
+		AllowList  []string `yaml:"allow_list"`
+		DenyList   []string `yaml:"deny_list"`
+		DenyAction string   `yaml:"deny_action"`
+		RateLimit  struct {
+			Enabled              bool   `yaml:"enabled"`
+			MaxRequestsPerMinute int    `yaml:"max_requests_per_minute"`
+			Burst                int    `yaml:"burst"`
+			Action               string `yaml:"action"`
+			MaxTrackedSources    int    `yaml:"max_tracked_sources"`
 		} `yaml:"rate_limit"`
 	} `yaml:"security"`
+
+	Authentication struct {
+		Enabled     bool     `yaml:"enabled"`
+		KeysFile    string   `yaml:"keys_file"`
+		TrustedKeys []uint32 `yaml:"trusted_keys"`
+		Require     bool     `yaml:"require"`
+		Chaos       struct {
+			BadMACFraction    float64 `yaml:"bad_mac_fraction"`
+			WrongKeyFraction  float64 `yaml:"wrong_key_fraction"`
+			CryptoNAKFraction float64 `yaml:"crypto_nak_fraction"`
+		} `yaml:"chaos"`
+	} `yaml:"authentication"`
+
+	NTS struct {
+		Enabled    bool   `yaml:"enabled"`
+		Host       string `yaml:"host"`
+		Port       int    `yaml:"port"`
+		CertFile   string `yaml:"cert_file"`
+		KeyFile    string `yaml:"key_file"`
+		ServerName string `yaml:"server_name"`
+		NTPServer  string `yaml:"ntp_server"`
+		NTPPort    int    `yaml:"ntp_port"`
+		Cookies    int    `yaml:"cookies"`
+		Require    bool   `yaml:"require"`
+		Chaos      struct {
+			BadCookieFraction   float64 `yaml:"bad_cookie_fraction"`
+			AEADFailureFraction float64 `yaml:"aead_failure_fraction"`
+			NAKFraction         float64 `yaml:"nak_fraction"`
+		} `yaml:"chaos"`
+	} `yaml:"nts"`
+
+	Broadcast struct {
+		Enabled         bool     `yaml:"enabled"`
+		Targets         []string `yaml:"targets"`
+		Port            int      `yaml:"port"`
+		IntervalSeconds int      `yaml:"interval_seconds"`
+		TTL             int      `yaml:"ttl"`
+		Version         int      `yaml:"version"`
+		KeyID           uint32   `yaml:"key_id"`
+	} `yaml:"broadcast"`
+
+	Symmetric struct {
+		Enabled     bool     `yaml:"enabled"`
+		Peers       []string `yaml:"peers"`
+		PollSeconds int      `yaml:"poll_seconds"`
+		KeyID       uint32   `yaml:"key_id"`
+		MaxPeers    int      `yaml:"max_peers"`
+	} `yaml:"symmetric"`
+
+	// RFC 868 TIME and RFC 867 DAYTIME listeners
+	Legacy struct {
+		Host string `yaml:"host"`
+		Time struct {
+			Enabled bool `yaml:"enabled"`
+			Port    int  `yaml:"port"`
+			TCP     bool `yaml:"tcp"`
+			UDP     bool `yaml:"udp"`
+		} `yaml:"time"`
+		Daytime struct {
+			Enabled bool `yaml:"enabled"`
+			Port    int  `yaml:"port"`
+			TCP     bool `yaml:"tcp"`
+			UDP     bool `yaml:"udp"`
+		} `yaml:"daytime"`
+	} `yaml:"legacy"`
+
+	Roughtime struct {
+		Enabled       bool    `yaml:"enabled"`
+		Host          string  `yaml:"host"`
+		Port          int     `yaml:"port"`
+		PrivateKey    string  `yaml:"private_key"`
+		RadiusSeconds float64 `yaml:"radius_seconds"`
+	} `yaml:"roughtime"`
+
+	// HTTP Date-header time source
+	HTTPTime struct {
+		Enabled bool   `yaml:"enabled"`
+		Host    string `yaml:"host"`
+		Port    int    `yaml:"port"`
+	} `yaml:"http_time"`
+
+	// Mode 6 control queries (ntpq)
+	Control struct {
+		Enabled  bool    `yaml:"enabled"`
+		Source   string  `yaml:"source"`
+		Upstream string  `yaml:"upstream"`
+		OffsetMs float64 `yaml:"offset_ms"`
+		JitterMs float64 `yaml:"jitter_ms"`
+		Version  string  `yaml:"version"`
+	} `yaml:"control"`
+
+	// Extension fields added to responses to exercise client parsers
+	ExtensionFields struct {
+		Strict bool                 `yaml:"strict"` // Drop requests whose trailer breaks RFC 7822
+		Inject []ExtensionInjection `yaml:"inject"`
+	} `yaml:"extension_fields"`
+
+	Persistence struct {
+		Enabled         bool   `yaml:"enabled"`
+		Path            string `yaml:"path"`
+		IntervalSeconds int    `yaml:"interval_seconds"`
+	} `yaml:"persistence"`
+
+	Admin struct {
+		Enabled bool   `yaml:"enabled"`
+		Host    string `yaml:"host"`
+		Port    int    `yaml:"port"`
+		Token   string `yaml:"token"`
+	} `yaml:"admin"`
+
+	Metrics struct {
+		Enabled bool   `yaml:"enabled"`
+		Host    string `yaml:"host"`
+		Port    int    `yaml:"port"`
+		Path    string `yaml:"path"`
+	} `yaml:"metrics"`
+}
+
+// DistributionParams holds the shape parameters for each offset/jitter
+// distribution. Values are fractions of the configured range, so 1.0 means
+// N minutes for the initial offset and X seconds for jitter.
+type DistributionParams struct {
+	Normal struct {
+		Mean   float64 `yaml:"mean"`
+		StdDev float64 `yaml:"stddev"`
+	} `yaml:"normal"`
+
+	Exponential struct {
+		Mean      float64 `yaml:"mean"`
+		Symmetric bool    `yaml:"symmetric"`
+	} `yaml:"exponential"`
+
+	Laplace struct {
+		Location float64 `yaml:"location"`
+		Scale    float64 `yaml:"scale"`
+	} `yaml:"laplace"`
+
+	Bimodal struct {
+		Separation float64 `yaml:"separation"`
+		StdDev     float64 `yaml:"stddev"`
+		Weight     float64 `yaml:"weight"`
+	} `yaml:"bimodal"`
+
+	Histogram []HistogramBin `yaml:"histogram"`
+}
+
+// HistogramBin is one bin of an empirical distribution
+type HistogramBin struct {
+	Min    float64 `yaml:"min"`
+	Max    float64 `yaml:"max"`
+	Weight float64 `yaml:"weight"`
 }
 
 // CLIFlags holds command-line flag values
@@ -59,6 +273,7 @@ type CLIFlags struct {
 	Port          int
 	Host          string
 	LogLevel      string
+	ScenarioFile  string
 }
 
 // ParseFlags parses command-line arguments
@@ -82,6 +297,7 @@ func ParseFlags() *CLIFlags {
 
 	flag.StringVar(&flags.Host, "host", "", "Bind address (overrides config)")
 	flag.StringVar(&flags.LogLevel, "log-level", "", "Logging level (overrides config)")
+	flag.StringVar(&flags.ScenarioFile, "scenario", "", "Path to scenario file (overrides config)")
 
 	flag.Parse()
 
@@ -95,6 +311,9 @@ func LoadConfig(configPath string, flags *CLIFlags) (*Config, error) {
 	config.Server.Host = "0.0.0.0"
 	config.Server.Port = 123
 	config.Server.Name = "ChaosNTPd"
+	config.Server.Workers = 0 // One per CPU
+	config.Server.QueueDepth = 1024
+	config.Server.KernelTimestamps = true
 
 	config.NTP.Stratum = 1
 	config.NTP.ReferenceID = "CHAO"
@@ -103,14 +322,85 @@ func LoadConfig(configPath string, flags *CLIFlags) (*Config, error) {
 	config.TimeManipulation.InitialOffsetMinutes = 30
 	config.TimeManipulation.JitterSeconds = 5
 	config.TimeManipulation.Distribution = "uniform"
+	setDefaultDistributionParams(&config.TimeManipulation.DistributionParams)
+	config.TimeManipulation.Drift.MinPPM = -100
+	config.TimeManipulation.Drift.MaxPPM = 100
 	config.TimeManipulation.ClientTracking.CleanupIntervalSeconds = 300
 	config.TimeManipulation.ClientTracking.MaxClientAgeSeconds = 3600
 	config.TimeManipulation.ClientTracking.MaxTrackedClients = 10000
+	config.TimeManipulation.ClientTracking.Key.Mode = KeyModeIP
+	config.TimeManipulation.ClientTracking.Key.IPv4Prefix = 24
+	config.TimeManipulation.ClientTracking.Key.IPv6Prefix = 64
+	config.TimeManipulation.ClientTracking.Key.FingerprintFields = []string{FingerprintVersion, FingerprintPrecision}
+
+	config.Leap.Event.Type = "insert"
+	config.Leap.Event.Mode = LeapModeStep
+	config.Leap.Event.AnnounceSeconds = 86400
+	config.Leap.Event.SmearWindowSeconds = 86400
+
+	config.KoD.Code = "RATE"
+
+	config.Security.DenyAction = DenyActionDrop
+	config.Security.RateLimit.MaxRequestsPerMinute = 60
+	config.Security.RateLimit.Action = RateLimitActionKoD
+	config.Security.RateLimit.MaxTrackedSources = 10000
+
+	config.NTS.Host = "0.0.0.0"
+	config.NTS.Port = 4460
+	config.NTS.ServerName = "localhost"
+	config.NTS.Cookies = 8
+
+	config.Broadcast.Targets = []string{"224.0.1.1"}
+	config.Broadcast.Port = 123
+	config.Broadcast.IntervalSeconds = 64
+	config.Broadcast.TTL = 1
+	config.Broadcast.Version = 4
+
+	config.Legacy.Host = "0.0.0.0"
+	config.Legacy.Time.Port = 37
+	config.Legacy.Time.TCP = true
+	config.Legacy.Time.UDP = true
+	config.Legacy.Daytime.Port = 13
+	config.Legacy.Daytime.TCP = true
+	config.Legacy.Daytime.UDP = true
+
+	config.Roughtime.Host = "0.0.0.0"
+	config.Roughtime.Port = 2002
+	config.Roughtime.RadiusSeconds = 1
+
+	config.HTTPTime.Host = "0.0.0.0"
+	config.HTTPTime.Port = 8080
+
+	config.Control.Source = ControlSourceGPS
+	config.Control.Upstream = "192.0.2.1"
+	config.Control.OffsetMs = 0.001
+	config.Control.JitterMs = 0.002
+	config.Control.Version = "ntpd 4.2.8p15@1.3728-o"
+
+	config.Symmetric.PollSeconds = 64
+	config.Symmetric.MaxPeers = 256
+
+	config.Persistence.Path = "chaosntpd-state.json"
+	config.Persistence.IntervalSeconds = 60
+
+	config.Admin.Host = "127.0.0.1"
+	config.Admin.Port = 8123
+
+	config.Metrics.Host = "0.0.0.0"
+	config.Metrics.Port = 9123
+	config.Metrics.Path = "/metrics"
 
 	config.Logging.Level = "INFO"
 	config.Logging.Format = "json"
 	config.Logging.LogTransactions = true
-	config.Logging.Output = "stdout"
+	config.Logging.Output = OutputStderr
+	config.Logging.File = "chaosntpd.log"
+	config.Logging.DiagnosticFormat = "text"
+	config.Logging.TransactionOutput = OutputStdout
+	config.Logging.TransactionFile = "transactions.log"
+	config.Logging.Rotation.MaxSizeMB = 100
+	config.Logging.Rotation.MaxAgeHours = 24
+	config.Logging.Rotation.MaxBackups = 7
 
 	// Load config file if it exists
 	if _, err := os.Stat(configPath); err == nil {
@@ -143,34 +433,312 @@ func LoadConfig(configPath string, flags *CLIFlags) (*Config, error) {
 	if flags.LogLevel != "" {
 		config.Logging.Level = flags.LogLevel
 	}
+	if flags.ScenarioFile != "" {
+		config.TimeManipulation.ScenarioFile = flags.ScenarioFile
+	}
+
+	// Load scenario file, replacing any inline scenario
+	if config.TimeManipulation.ScenarioFile != "" {
+		scenario, err := LoadScenario(config.TimeManipulation.ScenarioFile)
+		if err != nil {
+			return nil, err
+		}
+		config.TimeManipulation.Scenario = scenario
+	}
 
-	// Validate
-	if config.NTP.Stratum < 0 || config.NTP.Stratum > 15 {
-		return nil, fmt.Errorf("invalid stratum value: %d (must be 0-15)", config.NTP.Stratum)
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
 
 	return config, nil
 }
 
+// Validate checks the configuration for invalid values
+func (c *Config) Validate() error {
+	if c.NTP.Stratum < 0 || c.NTP.Stratum > 15 {
+		return fmt.Errorf("invalid stratum value: %d (must be 0-15)", c.NTP.Stratum)
+	}
+	if c.Server.Workers < 0 {
+		return fmt.Errorf("invalid worker count: %d (must not be negative)", c.Server.Workers)
+	}
+	if c.Server.QueueDepth <= 0 {
+		return fmt.Errorf("invalid queue depth: %d (must be positive)", c.Server.QueueDepth)
+	}
+	if err := c.ValidateManipulation(); err != nil {
+		return err
+	}
+	if err := c.validateLogging(); err != nil {
+		return err
+	}
+	if c.Persistence.Enabled {
+		if c.Persistence.Path == "" {
+			return fmt.Errorf("persistence is enabled but no path is set")
+		}
+		if c.Persistence.IntervalSeconds < 0 {
+			return fmt.Errorf("invalid persistence interval: %d seconds (must not be negative)", c.Persistence.IntervalSeconds)
+		}
+	}
+	if c.Admin.Enabled && (c.Admin.Port <= 0 || c.Admin.Port > 65535) {
+		return fmt.Errorf("invalid admin port: %d", c.Admin.Port)
+	}
+	if c.Metrics.Enabled && (c.Metrics.Port <= 0 || c.Metrics.Port > 65535) {
+		return fmt.Errorf("invalid metrics port: %d", c.Metrics.Port)
+	}
+	if _, err := NewLeapSimulator(c); err != nil {
+		return err
+	}
+	if _, err := NewKoDPolicy(c); err != nil {
+		return err
+	}
+	if _, err := NewAccessList(c); err != nil {
+		return err
+	}
+	if _, err := NewClientKeyer(c); err != nil {
+		return err
+	}
+	// The keys file is read once to check every key ID that refers to it
+	auth, err := NewAuthenticator(c)
+	if err != nil {
+		return err
+	}
+	if id := c.Symmetric.KeyID; c.Symmetric.Enabled && id != 0 && !auth.HasKey(id) {
+		return fmt.Errorf("symmetric key %d is not a loaded authentication key", id)
+	}
+	if id := c.Broadcast.KeyID; c.Broadcast.Enabled && id != 0 && !auth.HasKey(id) {
+		return fmt.Errorf("broadcast key %d is not a loaded authentication key", id)
+	}
+	if err := validateNTS(c); err != nil {
+		return err
+	}
+	if _, err := NewExtensionInjector(c); err != nil {
+		return err
+	}
+	if err := validateLegacy(c); err != nil {
+		return err
+	}
+	if c.Roughtime.Enabled {
+		if err := validateRoughtime(c); err != nil {
+			return err
+		}
+	}
+	if c.HTTPTime.Enabled && (c.HTTPTime.Port < 1 || c.HTTPTime.Port > 65535) {
+		return fmt.Errorf("invalid http_time port: %d (must be 1-65535)", c.HTTPTime.Port)
+	}
+	if _, err := NewControlResponder(c); err != nil {
+		return err
+	}
+	if _, err := NewPeerTable(c); err != nil {
+		return err
+	}
+	if c.Broadcast.Enabled {
+		if _, err := NewBroadcaster(c, nil); err != nil {
+			return err
+		}
+	}
+	if _, err := NewRateLimiter(c); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateLogging checks the logging levels, sinks and rotation limits
+func (c *Config) validateLogging() error {
+	lc := c.Logging
+	if _, err := ParseLogLevel(lc.Level); err != nil {
+		return err
+	}
+	if lc.Format != "json" && lc.Format != "text" {
+		return fmt.Errorf("invalid log format %q (must be json or text)", lc.Format)
+	}
+	if lc.DiagnosticFormat != "json" && lc.DiagnosticFormat != "text" {
+		return fmt.Errorf("invalid diagnostic log format %q (must be json or text)", lc.DiagnosticFormat)
+	}
+	if err := validateLogOutput(lc.Output, lc.File); err != nil {
+		return fmt.Errorf("diagnostic log: %w", err)
+	}
+	if err := validateLogOutput(lc.TransactionOutput, lc.TransactionFile); err != nil {
+		return fmt.Errorf("transaction log: %w", err)
+	}
+	if lc.Output == OutputFile && lc.TransactionOutput == OutputFile && filepath.Clean(lc.File) == filepath.Clean(lc.TransactionFile) {
+		return fmt.Errorf("diagnostic and transaction logs must use different files")
+	}
+	if lc.Rotation.MaxSizeMB < 0 || lc.Rotation.MaxAgeHours < 0 || lc.Rotation.MaxBackups < 0 {
+		return fmt.Errorf("invalid log rotation limits: values must not be negative")
+	}
+
+	return nil
+}
+
+// ValidateManipulation checks the time manipulation settings
+func (c *Config) ValidateManipulation() error {
+	tm := &c.TimeManipulation
+	if tm.InitialOffsetMinutes < 0 {
+		return fmt.Errorf("invalid initial offset: %d minutes (must not be negative)", tm.InitialOffsetMinutes)
+	}
+	if tm.JitterSeconds < 0 {
+		return fmt.Errorf("invalid jitter: %d seconds (must not be negative)", tm.JitterSeconds)
+	}
+	if _, err := NewDistribution(tm.Distribution, tm.DistributionParams); err != nil {
+		return fmt.Errorf("invalid distribution: %w", err)
+	}
+	if _, err := NewProfileSet(c); err != nil {
+		return fmt.Errorf("invalid profiles: %w", err)
+	}
+	if err := tm.Scenario.Validate(); err != nil {
+		return fmt.Errorf("invalid scenario: %w", err)
+	}
+	if tm.Drift.MaxPPM < tm.Drift.MinPPM {
+		return fmt.Errorf("invalid drift range: max_ppm (%g) is less than min_ppm (%g)", tm.Drift.MaxPPM, tm.Drift.MinPPM)
+	}
+	if tm.Drift.WanderPPM < 0 {
+		return fmt.Errorf("invalid drift wander: %g (must not be negative)", tm.Drift.WanderPPM)
+	}
+
+	return nil
+}
+
+// setDefaultDistributionParams fills in the default distribution shapes
+func setDefaultDistributionParams(p *DistributionParams) {
+	p.Normal.Mean = 0
+	p.Normal.StdDev = 1.0 / 3.0 // ±range is 3 sigma
+	p.Exponential.Mean = 0.5
+	p.Exponential.Symmetric = true
+	p.Laplace.Location = 0
+	p.Laplace.Scale = 0.5
+	p.Bimodal.Separation = 0.75
+	p.Bimodal.StdDev = 0.1
+	p.Bimodal.Weight = 0.5
+}
+
 // PrintStartupBanner prints the startup information
 func PrintStartupBanner(config *Config) {
-	fmt.Println("╔════════════════════════════════════════════════════════════════╗")
-	fmt.Println("║                        ChaosNTPd v1.0                          ║")
-	fmt.Println("║           Adversarial NTP Daemon for Testing                  ║")
-	fmt.Println("╚════════════════════════════════════════════════════════════════╝")
-	fmt.Println()
-	fmt.Println("⚠️  WARNING: This server distributes INACCURATE time information!")
-	fmt.Println("⚠️  Deploy ONLY in isolated test environments!")
-	fmt.Println()
-	fmt.Printf("Configuration:\n")
-	fmt.Printf("  Listening:      %s:%d\n", config.Server.Host, config.Server.Port)
-	fmt.Printf("  Stratum:        %d (0=invalid, 1=primary, 2-15=secondary)\n", config.NTP.Stratum)
-	fmt.Printf("  Reference ID:   %s\n", config.NTP.ReferenceID)
-	fmt.Printf("  Initial Offset: ±%d minutes\n", config.TimeManipulation.InitialOffsetMinutes)
-	fmt.Printf("  Jitter:         ±%d seconds\n", config.TimeManipulation.JitterSeconds)
-	fmt.Printf("  Distribution:   %s\n", config.TimeManipulation.Distribution)
-	fmt.Printf("  Log Format:     %s\n", config.Logging.Format)
-	fmt.Println()
-	fmt.Println("Starting server...")
-	fmt.Println()
+	// The banner goes to stderr so stdout can carry a clean transaction stream
+	w := os.Stderr
+
+	fmt.Fprintln(w, "╔════════════════════════════════════════════════════════════════╗")
+	fmt.Fprintln(w, "║                        ChaosNTPd v1.0                          ║")
+	fmt.Fprintln(w, "║           Adversarial NTP Daemon for Testing                  ║")
+	fmt.Fprintln(w, "╚════════════════════════════════════════════════════════════════╝")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "⚠️  WARNING: This server distributes INACCURATE time information!")
+	fmt.Fprintln(w, "⚠️  Deploy ONLY in isolated test environments!")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Configuration:\n")
+	fmt.Fprintf(w, "  Listening:      %s:%d\n", config.Server.Host, config.Server.Port)
+	fmt.Fprintf(w, "  Stratum:        %d (0=invalid, 1=primary, 2-15=secondary)\n", config.NTP.Stratum)
+	fmt.Fprintf(w, "  Reference ID:   %s\n", config.NTP.ReferenceID)
+	fmt.Fprintf(w, "  Initial Offset: ±%d minutes\n", config.TimeManipulation.InitialOffsetMinutes)
+	fmt.Fprintf(w, "  Jitter:         ±%d seconds\n", config.TimeManipulation.JitterSeconds)
+	fmt.Fprintf(w, "  Distribution:   %s\n", config.TimeManipulation.Distribution)
+	if drift := config.TimeManipulation.Drift; drift.Enabled {
+		if drift.MinPPM == drift.MaxPPM {
+			fmt.Fprintf(w, "  Drift:          %g PPM", drift.MinPPM)
+		} else {
+			fmt.Fprintf(w, "  Drift:          %g to %g PPM", drift.MinPPM, drift.MaxPPM)
+		}
+		if drift.WanderPPM > 0 {
+			fmt.Fprintf(w, " (wander %g PPM/√h)", drift.WanderPPM)
+		}
+		fmt.Fprintln(w)
+	}
+	if scenario := config.TimeManipulation.Scenario; scenario.Enabled() {
+		name := scenario.Name
+		if name == "" {
+			name = "unnamed"
+		}
+		loop := ""
+		if scenario.Loop {
+			loop = ", looping"
+		}
+		fmt.Fprintf(w, "  Scenario:       %s (%d phases, %s clock%s)\n", name, len(scenario.Phases), scenario.Clock, loop)
+	}
+	if len(config.Profiles) > 0 {
+		names := make([]string, len(config.Profiles))
+		for i, profile := range config.Profiles {
+			names[i] = profile.Name
+		}
+		fmt.Fprintf(w, "  Profiles:       %s\n", strings.Join(names, ", "))
+	}
+	switch key := config.TimeManipulation.ClientTracking.Key; strings.ToLower(key.Mode) {
+	case KeyModeSubnet:
+		fmt.Fprintf(w, "  Client Key:     subnet (/%d, /%d)\n", key.IPv4Prefix, key.IPv6Prefix)
+	case KeyModeIPPort, KeyModeFingerprint:
+		fmt.Fprintf(w, "  Client Key:     %s\n", key.Mode)
+	}
+	if config.Leap.Indicator != LeapNone {
+		fmt.Fprintf(w, "  Leap Indicator: %d\n", config.Leap.Indicator)
+	}
+	if event := config.Leap.Event; event.Enabled {
+		fmt.Fprintf(w, "  Leap Second:    %s at %s (%s)\n", event.Type, event.Time, event.Mode)
+	}
+	if len(config.Security.AllowList) > 0 || len(config.Security.DenyList) > 0 {
+		fmt.Fprintf(w, "  Access List:    %d allowed, %d denied networks (%s)\n",
+			len(config.Security.AllowList), len(config.Security.DenyList), config.Security.DenyAction)
+	}
+	if rl := config.Security.RateLimit; rl.Enabled {
+		fmt.Fprintf(w, "  Rate Limit:     %d req/min per source (%s)\n", rl.MaxRequestsPerMinute, rl.Action)
+	}
+	if kod := config.KoD; kod.Enabled {
+		fmt.Fprintf(w, "  Kiss-o'-Death:  %s for %.1f%% of responses, %d client rules\n",
+			kod.Code, kod.Fraction*100, len(kod.Clients))
+	}
+	if auth := config.Authentication; auth.Enabled {
+		required := ""
+		if auth.Require {
+			required = ", required"
+		}
+		fmt.Fprintf(w, "  Authentication: %s%s\n", auth.KeysFile, required)
+	}
+	if nts := config.NTS; nts.Enabled {
+		fmt.Fprintf(w, "  NTS-KE:         %s:%d (%s, %d cookies)\n", nts.Host, nts.Port, nts.ServerName, nts.Cookies)
+	}
+	if bc := config.Broadcast; bc.Enabled {
+		fmt.Fprintf(w, "  Broadcast:      %s port %d every %ds\n", strings.Join(bc.Targets, ", "), bc.Port, bc.IntervalSeconds)
+	}
+	if lc := config.Legacy; lc.Time.Enabled || lc.Daytime.Enabled {
+		var services []string
+		if lc.Time.Enabled {
+			services = append(services, fmt.Sprintf("TIME :%d", lc.Time.Port))
+		}
+		if lc.Daytime.Enabled {
+			services = append(services, fmt.Sprintf("DAYTIME :%d", lc.Daytime.Port))
+		}
+		fmt.Fprintf(w, "  Legacy:         %s\n", strings.Join(services, ", "))
+	}
+	if rc := config.Roughtime; rc.Enabled {
+		fmt.Fprintf(w, "  Roughtime:      %s:%d (radius %gs)\n", rc.Host, rc.Port, rc.RadiusSeconds)
+	}
+	if ht := config.HTTPTime; ht.Enabled {
+		fmt.Fprintf(w, "  HTTP Time:      %s:%d\n", ht.Host, ht.Port)
+	}
+	if config.Control.Enabled {
+		fmt.Fprintf(w, "  Control (ntpq): claims %s\n", config.Control.Source)
+	}
+	if sc := config.Symmetric; sc.Enabled {
+		peers := "passive only"
+		if len(sc.Peers) > 0 {
+			peers = fmt.Sprintf("polling %s every %ds", strings.Join(sc.Peers, ", "), sc.PollSeconds)
+		}
+		fmt.Fprintf(w, "  Symmetric:      %s\n", peers)
+	}
+	if n := len(config.ExtensionFields.Inject); n > 0 {
+		fmt.Fprintf(w, "  Extensions:     %d injection rule(s)\n", n)
+	}
+	if config.ExtensionFields.Strict {
+		fmt.Fprintf(w, "  Ext. Parsing:   strict\n")
+	}
+	if config.Persistence.Enabled {
+		fmt.Fprintf(w, "  State File:     %s (every %ds)\n", config.Persistence.Path, config.Persistence.IntervalSeconds)
+	}
+	if config.Admin.Enabled {
+		fmt.Fprintf(w, "  Admin API:      %s:%d\n", config.Admin.Host, config.Admin.Port)
+	}
+	if config.Metrics.Enabled {
+		fmt.Fprintf(w, "  Metrics:        %s:%d%s\n", config.Metrics.Host, config.Metrics.Port, config.Metrics.Path)
+	}
+	fmt.Fprintf(w, "  Log Format:     %s\n", config.Logging.Format)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Starting server...")
+	fmt.Fprintln(w)
 }
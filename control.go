@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Mode 6 control message opcodes (RFC 1305 appendix B)
+const (
+	ctlOpReadStat = 1
+	ctlOpReadVar  = 2
+)
+
+// Mode 6 error codes, sent in the status word of error responses
+const (
+	ctlErrPermission = 1
+	ctlErrBadOp      = 3
+	ctlErrBadAssoc   = 4
+)
+
+// Mode 6 framing
+const (
+	ctlHeaderSize  = 12
+	ctlMaxData     = 468 // Data bytes per response fragment
+	ctlResponse    = 0x80
+	ctlError       = 0x40
+	ctlMore        = 0x20
+	ctlOpcodeMask  = 0x1f
+	ctlLineLength  = 72 // Variable lists are wrapped like ntpd's
+	ctlFakeAssocID = 0x9d14
+)
+
+// Claimed synchronization sources
+const (
+	ControlSourceGPS   = "gps"
+	ControlSourcePPS   = "pps"
+	ControlSourceNTP   = "ntp"
+	ControlSourceLocal = "local"
+)
+
+// controlSource describes the fake system peer for one claimed source
+type controlSource struct {
+	clockType uint8  // System status clock source (ntpd CTL_SST_TS_*)
+	srcadr    string // Peer address; 127.127.t.u for reference clocks
+	refid     string // Peer refid when it is a reference clock
+	hmode     int
+	poll      int
+}
+
+var controlSources = map[string]controlSource{
+	ControlSourceGPS:   {clockType: 4, srcadr: "127.127.20.0", refid: "GPS", hmode: 3, poll: 4}, // NMEA, UHF (satellite)
+	ControlSourcePPS:   {clockType: 1, srcadr: "127.127.22.0", refid: "PPS", hmode: 3, poll: 4}, // Atomic/PPS
+	ControlSourceNTP:   {clockType: 6, hmode: 3, poll: 6},                                       // Upstream NTP server
+	ControlSourceLocal: {clockType: 5, srcadr: "127.127.1.0", refid: "LOCL", hmode: 3, poll: 6}, // Undisciplined local clock
+}
+
+// controlMessage is a parsed mode 6 request
+type controlMessage struct {
+	version  uint8
+	opcode   uint8
+	sequence uint16
+	assocID  uint16
+	data     []byte
+}
+
+// ControlResponder answers ntpq-style mode 6 queries (readstat, readvar,
+// peer listing) with fake system and peer variables consistent with the
+// configured stratum and reference ID
+type ControlResponder struct {
+	enabled  bool
+	source   controlSource
+	upstream string
+	offset   float64 // ms
+	jitter   float64 // ms
+	version  string
+	stratum  int
+	refID    string
+	precis   int
+}
+
+// NewControlResponder builds the mode 6 responder from the configuration
+func NewControlResponder(config *Config) (*ControlResponder, error) {
+	cc := config.Control
+	responder := &ControlResponder{
+		enabled:  cc.Enabled,
+		upstream: cc.Upstream,
+		offset:   cc.OffsetMs,
+		jitter:   cc.JitterMs,
+		version:  cc.Version,
+		stratum:  config.NTP.Stratum,
+		refID:    config.NTP.ReferenceID,
+		precis:   config.NTP.Precision,
+	}
+	if !cc.Enabled {
+		return responder, nil
+	}
+
+	source, ok := controlSources[strings.ToLower(cc.Source)]
+	if !ok {
+		return nil, fmt.Errorf("invalid control source: %q (must be %s, %s, %s or %s)",
+			cc.Source, ControlSourceGPS, ControlSourcePPS, ControlSourceNTP, ControlSourceLocal)
+	}
+	if source.srcadr == "" {
+		if net.ParseIP(cc.Upstream) == nil {
+			return nil, fmt.Errorf("invalid control upstream: %q (must be an IP address)", cc.Upstream)
+		}
+		source.srcadr = cc.Upstream
+	}
+	if cc.JitterMs < 0 {
+		return nil, fmt.Errorf("invalid control jitter: %g (must not be negative)", cc.JitterMs)
+	}
+	responder.source = source
+
+	return responder, nil
+}
+
+// Enabled reports whether mode 6 queries are answered
+func (c *ControlResponder) Enabled() bool {
+	return c.enabled
+}
+
+// parseControlMessage parses a mode 6 request
+func parseControlMessage(data []byte) (*controlMessage, error) {
+	if len(data) < ctlHeaderSize {
+		return nil, fmt.Errorf("invalid control message: too short (%d bytes)", len(data))
+	}
+	if data[1]&(ctlResponse|ctlError|ctlMore) != 0 {
+		return nil, errors.New("invalid control message: not a request")
+	}
+
+	count := int(binary.BigEndian.Uint16(data[10:12]))
+	if ctlHeaderSize+count > len(data) {
+		return nil, fmt.Errorf("invalid control message: count %d exceeds packet", count)
+	}
+
+	return &controlMessage{
+		version:  (data[0] >> 3) & 0x07,
+		opcode:   data[1] & ctlOpcodeMask,
+		sequence: binary.BigEndian.Uint16(data[2:4]),
+		assocID:  binary.BigEndian.Uint16(data[6:8]),
+		data:     data[ctlHeaderSize : ctlHeaderSize+count],
+	}, nil
+}
+
+// Respond answers a request at the given manipulated time and leap
+// indicator. It returns the status word and data, or a mode 6 error code.
+func (c *ControlResponder) Respond(msg *controlMessage, now time.Time, leap uint8) (uint16, []byte, int) {
+	switch msg.opcode {
+	case ctlOpReadStat:
+		if msg.assocID == 0 {
+			data := binary.BigEndian.AppendUint16(nil, ctlFakeAssocID)
+			data = binary.BigEndian.AppendUint16(data, c.peerStatus())
+			return c.systemStatus(leap), data, 0
+		}
+		if msg.assocID != ctlFakeAssocID {
+			return 0, nil, ctlErrBadAssoc
+		}
+		return c.peerStatus(), nil, 0
+
+	case ctlOpReadVar:
+		requested := parseVarNames(msg.data)
+		switch msg.assocID {
+		case 0:
+			return c.systemStatus(leap), formatVars(c.systemVars(now, leap), requested), 0
+		case ctlFakeAssocID:
+			return c.peerStatus(), formatVars(c.peerVars(now), requested), 0
+		}
+		return 0, nil, ctlErrBadAssoc
+
+	case 3, 5, 9: // writevar, writeclock, configure
+		return 0, nil, ctlErrPermission
+	}
+	return 0, nil, ctlErrBadOp
+}
+
+// systemStatus is the system status word: leap, clock source and the
+// last event (one "clock_sync")
+func (c *ControlResponder) systemStatus(leap uint8) uint16 {
+	return uint16(leap)<<14 | uint16(c.source.clockType&0x3f)<<8 | 1<<4 | 5
+}
+
+// peerStatus is the peer status word of a reachable, configured system
+// peer whose last event was "sys_peer"
+func (c *ControlResponder) peerStatus() uint16 {
+	const configured, reachable, sysPeer = 0x80, 0x10, 6
+	return (configured|reachable|sysPeer)<<8 | 1<<4 | 0xa
+}
+
+// systemVars are the variables of readvar on association 0
+func (c *ControlResponder) systemVars(now time.Time, leap uint8) []controlVar {
+	reftime := now.Add(-time.Duration(1<<c.source.poll) * time.Second / 2)
+	return []controlVar{
+		{"version", quote(c.version)},
+		{"processor", quote(runtime.GOARCH)},
+		{"system", quote(runtime.GOOS)},
+		{"leap", fmt.Sprintf("%02b", leap)},
+		{"stratum", fmt.Sprint(c.stratum)},
+		{"precision", fmt.Sprint(c.precis)},
+		{"rootdelay", "0.000"},
+		{"rootdisp", "0.000"},
+		{"refid", c.refIDString()},
+		{"reftime", ntpHex(reftime)},
+		{"clock", ntpHex(now)},
+		{"peer", fmt.Sprint(ctlFakeAssocID)},
+		{"tc", fmt.Sprint(c.source.poll)},
+		{"mintc", "3"},
+		{"offset", fmt.Sprintf("%.6f", c.offset)},
+		{"frequency", "0.000"},
+		{"sys_jitter", fmt.Sprintf("%.6f", c.jitter)},
+		{"clk_jitter", fmt.Sprintf("%.3f", c.jitter)},
+		{"clk_wander", "0.000"},
+	}
+}
+
+// peerVars are the variables of readvar on the fake system peer
+func (c *ControlResponder) peerVars(now time.Time) []controlVar {
+	stratum, refid := 0, c.source.refid
+	if c.source.clockType == controlSources[ControlSourceNTP].clockType {
+		// The claimed upstream is itself a GPS-disciplined server
+		stratum, refid = c.stratum-1, "GPS"
+		if stratum < 1 {
+			stratum = 1
+		}
+	} else if c.stratum == 1 {
+		refid = c.refIDString() // A stratum 1 server passes on its clock's ID
+	}
+
+	received := now.Add(-time.Duration(1<<c.source.poll) * time.Second / 2)
+	filter := func(v float64) string {
+		return strings.TrimSpace(strings.Repeat(fmt.Sprintf("%8.3f", v), 8))
+	}
+	return []controlVar{
+		{"srcadr", c.source.srcadr},
+		{"srcport", "123"},
+		{"dstadr", "127.0.0.1"},
+		{"dstport", "123"},
+		{"leap", "00"},
+		{"stratum", fmt.Sprint(stratum)},
+		{"precision", fmt.Sprint(c.precis)},
+		{"rootdelay", "0.000"},
+		{"rootdisp", "0.000"},
+		{"refid", refid},
+		{"reftime", ntpHex(received)},
+		{"rec", ntpHex(received)},
+		{"reach", "0xff"},
+		{"unreach", "0"},
+		{"hmode", fmt.Sprint(c.source.hmode)},
+		{"pmode", "4"},
+		{"hpoll", fmt.Sprint(c.source.poll)},
+		{"ppoll", fmt.Sprint(c.source.poll)},
+		{"headway", "0"},
+		{"flash", "0x0"},
+		{"keyid", "0"},
+		{"offset", fmt.Sprintf("%.3f", c.offset)},
+		{"delay", "0.000"},
+		{"dispersion", "0.938"},
+		{"jitter", fmt.Sprintf("%.3f", c.jitter)},
+		{"filtdelay", filter(0)},
+		{"filtoffset", filter(c.offset)},
+		{"filtdisp", filter(0.938)},
+	}
+}
+
+// refIDString renders the configured reference ID like ntpd does
+func (c *ControlResponder) refIDString() string {
+	refID := c.refID
+	if len(refID) > 4 {
+		refID = refID[:4]
+	}
+	return refID
+}
+
+// controlVar is one name=value pair of a variable list
+type controlVar struct {
+	name  string
+	value string
+}
+
+// parseVarNames extracts the names of a readvar request's variable list
+func parseVarNames(data []byte) map[string]bool {
+	list := strings.TrimRight(string(data), "\x00\r\n ")
+	if list == "" {
+		return nil
+	}
+
+	names := make(map[string]bool)
+	for _, item := range strings.Split(list, ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(item), "=")
+		names[name] = true
+	}
+	return names
+}
+
+// formatVars renders variables as ntpd does: comma separated, wrapped at
+// ctlLineLength with CRLF. Only requested variables are included, unless
+// none were requested; unknown names are ignored.
+func formatVars(vars []controlVar, requested map[string]bool) []byte {
+	var b []byte
+	line := 0
+	for _, v := range vars {
+		if requested != nil && !requested[v.name] {
+			continue
+		}
+		item := v.name + "=" + v.value
+		if len(b) > 0 {
+			if line+2+len(item) > ctlLineLength {
+				b = append(b, ",\r\n"...)
+				line = 0
+			} else {
+				b = append(b, ", "...)
+				line += 2
+			}
+		}
+		b = append(b, item...)
+		line += len(item)
+	}
+	if len(b) > 0 {
+		b = append(b, "\r\n"...)
+	}
+	return b
+}
+
+// controlFragments encodes a response, splitting data into fragments of
+// at most ctlMaxData bytes, each padded to a multiple of 4
+func controlFragments(msg *controlMessage, status uint16, data []byte, errCode int) [][]byte {
+	header := func(flags byte, status uint16, offset, count int) []byte {
+		b := make([]byte, ctlHeaderSize, ctlHeaderSize+ctlMaxData)
+		b[0] = msg.version<<3 | 6
+		b[1] = ctlResponse | flags | msg.opcode
+		binary.BigEndian.PutUint16(b[2:4], msg.sequence)
+		binary.BigEndian.PutUint16(b[4:6], status)
+		binary.BigEndian.PutUint16(b[6:8], msg.assocID)
+		binary.BigEndian.PutUint16(b[8:10], uint16(offset))
+		binary.BigEndian.PutUint16(b[10:12], uint16(count))
+		return b
+	}
+
+	if errCode != 0 {
+		return [][]byte{header(ctlError, uint16(errCode)<<8, 0, 0)}
+	}
+
+	var fragments [][]byte
+	for offset := 0; offset == 0 || offset < len(data); offset += ctlMaxData {
+		end := offset + ctlMaxData
+		var flags byte
+		if end < len(data) {
+			flags = ctlMore
+		} else {
+			end = len(data)
+		}
+		fragment := append(header(flags, status, offset, end-offset), data[offset:end]...)
+		fragments = append(fragments, append(fragment, make([]byte, padTo4(len(fragment))-len(fragment))...))
+	}
+	return fragments
+}
+
+// handleControl answers a mode 6 control query. Access lists and rate
+// limits apply; anything not permitted is dropped.
+func (s *NTPServer) handleControl(data []byte, clientAddr *net.UDPAddr, rxTime time.Time) {
+	p := s.policies.Load()
+	s.metrics.RecordRequest(6, (data[0]>>3)&0x07)
+
+	if !s.admit(p, clientAddr, "control") {
+		return
+	}
+	if !p.control.Enabled() {
+		LogWarning("Ignoring non-client request (mode 6) from %s", clientAddr.String())
+		s.metrics.RecordResponse(OutcomeDropped, "unsupported_mode")
+		return
+	}
+
+	msg, err := parseControlMessage(data)
+	if err != nil {
+		LogError("Error parsing control message from %s: %v", clientAddr.String(), err)
+		s.metrics.RecordResponse(OutcomeDropped, "malformed")
+		return
+	}
+
+	// The querying host sees its current timeline, or the real clock if it
+	// has none; monitoring never creates or advances a timeline
+	manipulatedTime := s.tracker.ProjectedTime(p.keyer.KeyForHost(clientAddr.IP), rxTime)
+	servedTime, leapIndicator := p.leap.Apply(manipulatedTime)
+
+	status, payload, errCode := p.control.Respond(msg, servedTime, leapIndicator)
+	for _, fragment := range controlFragments(msg, status, payload, errCode) {
+		if _, err := s.conn.WriteToUDP(fragment, clientAddr); err != nil {
+			LogError("Error sending control response to %s: %v", clientAddr.String(), err)
+			s.metrics.RecordResponse(OutcomeDropped, "send_error")
+			return
+		}
+	}
+	s.metrics.RecordResponse(OutcomeServed, "control")
+	LogDebug("Control opcode %d (association %d) from %s answered", msg.opcode, msg.assocID, clientAddr.String())
+}
+
+// ntpHex formats a time as an NTP timestamp in ntpd's hex notation
+func ntpHex(t time.Time) string {
+	ts := UnixToNTP(t)
+	return fmt.Sprintf("0x%08x.%08x", ts>>32, ts&0xffffffff)
+}
+
+// quote wraps a string value in double quotes
+func quote(s string) string {
+	return `"` + s + `"`
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+)
+
+// Distribution draws random samples used for initial offsets and jitter.
+// Samples are unitless and scaled by the caller to the configured range, so
+// a uniform distribution returns values in [-1, 1).
+type Distribution interface {
+	Name() string
+	Sample(r *rand.Rand) float64
+}
+
+// DistributionNames lists the supported distribution names
+var DistributionNames = []string{"uniform", "normal", "exponential", "laplace", "bimodal", "histogram"}
+
+// NewDistribution builds the named distribution from its parameters
+func NewDistribution(name string, params DistributionParams) (Distribution, error) {
+	switch strings.ToLower(name) {
+	case "", "uniform":
+		return uniformDistribution{}, nil
+
+	case "normal", "gaussian":
+		p := params.Normal
+		if p.StdDev <= 0 {
+			return nil, fmt.Errorf("normal distribution stddev must be positive (got %g)", p.StdDev)
+		}
+		return normalDistribution{mean: p.Mean, stddev: p.StdDev}, nil
+
+	case "exponential":
+		p := params.Exponential
+		if p.Mean <= 0 {
+			return nil, fmt.Errorf("exponential distribution mean must be positive (got %g)", p.Mean)
+		}
+		return exponentialDistribution{mean: p.Mean, symmetric: p.Symmetric}, nil
+
+	case "laplace":
+		p := params.Laplace
+		if p.Scale <= 0 {
+			return nil, fmt.Errorf("laplace distribution scale must be positive (got %g)", p.Scale)
+		}
+		return laplaceDistribution{location: p.Location, scale: p.Scale}, nil
+
+	case "bimodal":
+		p := params.Bimodal
+		if p.StdDev <= 0 {
+			return nil, fmt.Errorf("bimodal distribution stddev must be positive (got %g)", p.StdDev)
+		}
+		if p.Weight < 0 || p.Weight > 1 {
+			return nil, fmt.Errorf("bimodal distribution weight must be 0-1 (got %g)", p.Weight)
+		}
+		return bimodalDistribution{separation: p.Separation, stddev: p.StdDev, weight: p.Weight}, nil
+
+	case "histogram", "empirical":
+		return newHistogramDistribution(params.Histogram)
+	}
+
+	return nil, fmt.Errorf("unknown distribution %q (must be one of: %s)",
+		name, strings.Join(DistributionNames, ", "))
+}
+
+// uniformDistribution samples evenly across [-1, 1)
+type uniformDistribution struct{}
+
+func (uniformDistribution) Name() string { return "uniform" }
+
+func (uniformDistribution) Sample(r *rand.Rand) float64 {
+	return -1 + 2*r.Float64()
+}
+
+// normalDistribution samples a Gaussian; values beyond ±1 are not clipped
+type normalDistribution struct {
+	mean   float64
+	stddev float64
+}
+
+func (d normalDistribution) Name() string { return "normal" }
+
+func (d normalDistribution) Sample(r *rand.Rand) float64 {
+	return d.mean + d.stddev*r.NormFloat64()
+}
+
+// exponentialDistribution samples an exponential magnitude, optionally with
+// a random sign. One-sided samples are always positive (client clock ahead).
+type exponentialDistribution struct {
+	mean      float64
+	symmetric bool
+}
+
+func (d exponentialDistribution) Name() string { return "exponential" }
+
+func (d exponentialDistribution) Sample(r *rand.Rand) float64 {
+	v := d.mean * r.ExpFloat64()
+	if d.symmetric && r.Intn(2) == 0 {
+		return -v
+	}
+	return v
+}
+
+// laplaceDistribution samples a double-exponential around a location
+type laplaceDistribution struct {
+	location float64
+	scale    float64
+}
+
+func (d laplaceDistribution) Name() string { return "laplace" }
+
+func (d laplaceDistribution) Sample(r *rand.Rand) float64 {
+	// Inverse CDF with u in (-0.5, 0.5)
+	u := r.Float64() - 0.5
+	for u == -0.5 {
+		u = r.Float64() - 0.5
+	}
+	sign := 1.0
+	if u < 0 {
+		sign = -1.0
+	}
+	return d.location - d.scale*sign*math.Log(1-2*math.Abs(u))
+}
+
+// bimodalDistribution samples from two Gaussians centred at ±separation.
+// Weight is the probability of picking the positive mode.
+type bimodalDistribution struct {
+	separation float64
+	stddev     float64
+	weight     float64
+}
+
+func (d bimodalDistribution) Name() string { return "bimodal" }
+
+func (d bimodalDistribution) Sample(r *rand.Rand) float64 {
+	center := -d.separation
+	if r.Float64() < d.weight {
+		center = d.separation
+	}
+	return center + d.stddev*r.NormFloat64()
+}
+
+// histogramDistribution samples from a user-supplied empirical histogram.
+// A bin is picked by weight and the value is uniform within the bin.
+type histogramDistribution struct {
+	bins       []HistogramBin
+	cumulative []float64
+}
+
+func newHistogramDistribution(bins []HistogramBin) (Distribution, error) {
+	if len(bins) == 0 {
+		return nil, fmt.Errorf("histogram distribution requires at least one bin")
+	}
+
+	d := histogramDistribution{
+		bins:       bins,
+		cumulative: make([]float64, len(bins)),
+	}
+
+	total := 0.0
+	for i, bin := range bins {
+		if bin.Max < bin.Min {
+			return nil, fmt.Errorf("histogram bin %d: max (%g) is less than min (%g)", i, bin.Max, bin.Min)
+		}
+		if bin.Weight < 0 {
+			return nil, fmt.Errorf("histogram bin %d: weight must not be negative (got %g)", i, bin.Weight)
+		}
+		total += bin.Weight
+		d.cumulative[i] = total
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("histogram distribution weights must sum to a positive value")
+	}
+
+	return d, nil
+}
+
+func (d histogramDistribution) Name() string { return "histogram" }
+
+func (d histogramDistribution) Sample(r *rand.Rand) float64 {
+	target := r.Float64() * d.cumulative[len(d.cumulative)-1]
+
+	i := 0
+	for i < len(d.cumulative)-1 && d.cumulative[i] <= target {
+		i++
+	}
+
+	bin := d.bins[i]
+	return bin.Min + r.Float64()*(bin.Max-bin.Min)
+}
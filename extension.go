@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExtensionFieldType identifies an NTPv4 extension field
+type ExtensionFieldType uint16
+
+// Known extension field types
+const (
+	ExtUniqueIdentifier     ExtensionFieldType = 0x0104 // RFC 8915
+	ExtNTSCookie            ExtensionFieldType = 0x0204 // RFC 8915
+	ExtNTSCookiePlaceholder ExtensionFieldType = 0x0304 // RFC 8915
+	ExtNTSAuthenticator     ExtensionFieldType = 0x0404 // RFC 8915
+	ExtChecksumComplement   ExtensionFieldType = 0x2005 // RFC 7821
+)
+
+// RFC 7822 size rules
+const (
+	extMinLength     = 16 // Any extension field
+	extMinLastLength = 28 // The last field of a packet without a MAC
+)
+
+var extensionFieldNames = map[ExtensionFieldType]string{
+	ExtUniqueIdentifier:     "unique_identifier",
+	ExtNTSCookie:            "nts_cookie",
+	ExtNTSCookiePlaceholder: "nts_cookie_placeholder",
+	ExtNTSAuthenticator:     "nts_authenticator",
+	ExtChecksumComplement:   "checksum_complement",
+}
+
+// String returns the field type's name, or its hex code if unknown
+func (t ExtensionFieldType) String() string {
+	if name, ok := extensionFieldNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", uint16(t))
+}
+
+// ExtensionField is an NTPv4 extension field (RFC 7822). Value holds the
+// body including any padding, so parsed fields round-trip unchanged.
+type ExtensionField struct {
+	Type  ExtensionFieldType
+	Value []byte
+
+	// Length, if set, overrides the encoded length field. Only used to
+	// generate deliberately malformed fields.
+	Length *uint16
+}
+
+// EncodedLength returns the length field written on the wire
+func (ef ExtensionField) EncodedLength() int {
+	if ef.Length != nil {
+		return int(*ef.Length)
+	}
+	return 4 + len(ef.Value)
+}
+
+// appendTo encodes the field exactly as described, without padding
+func (ef ExtensionField) appendTo(b []byte) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(ef.Type))
+	b = binary.BigEndian.AppendUint16(b, uint16(ef.EncodedLength()))
+	return append(b, ef.Value...)
+}
+
+// Validate checks the field against the RFC 7822 length and padding rules
+func (ef ExtensionField) Validate() error {
+	length := ef.EncodedLength()
+	if length < extMinLength {
+		return fmt.Errorf("extension field %s: length %d is below the %d byte minimum", ef.Type, length, extMinLength)
+	}
+	if length != 4+len(ef.Value) {
+		return fmt.Errorf("extension field %s: length %d does not match %d byte body", ef.Type, length, len(ef.Value))
+	}
+	if length%4 != 0 {
+		return fmt.Errorf("extension field %s: length %d is not a multiple of 4", ef.Type, length)
+	}
+	return nil
+}
+
+// parseTrailer splits the bytes after the NTP header into extension fields
+// and an optional MAC. A remainder of exactly 4 (crypto-NAK), 20 (MD5,
+// AES-CMAC) or 24 (SHA1) bytes is a MAC; RFC 7822 sizes make this
+// unambiguous. Versions before 4 have no extension fields, so anything
+// but a MAC is ignored for them.
+func parseTrailer(trailer []byte, version uint8) ([]ExtensionField, *MAC, error) {
+	var fields []ExtensionField
+	for len(trailer) > 0 {
+		if n := len(trailer); n == 4 || n == 20 || n == 24 {
+			mac := &MAC{
+				KeyID:  binary.BigEndian.Uint32(trailer[:4]),
+				Digest: append([]byte(nil), trailer[4:]...),
+			}
+			return fields, mac, nil
+		}
+		if version < 4 {
+			return nil, nil, nil
+		}
+
+		if len(trailer) < extMinLength {
+			return nil, nil, fmt.Errorf("truncated extension field (%d bytes left)", len(trailer))
+		}
+		ef := ExtensionField{Type: ExtensionFieldType(binary.BigEndian.Uint16(trailer[0:2]))}
+		length := int(binary.BigEndian.Uint16(trailer[2:4]))
+		if length > len(trailer) {
+			return nil, nil, fmt.Errorf("extension field %s: length %d exceeds the %d bytes left", ef.Type, length, len(trailer))
+		}
+		if length < 4 {
+			return nil, nil, fmt.Errorf("extension field %s: invalid length %d", ef.Type, length)
+		}
+		ef.Value = append([]byte(nil), trailer[4:length]...)
+		if err := ef.Validate(); err != nil {
+			return nil, nil, err
+		}
+
+		fields = append(fields, ef)
+		trailer = trailer[length:]
+		if len(trailer) == 0 && length < extMinLastLength {
+			return nil, nil, fmt.Errorf("extension field %s: last field without a MAC must be at least %d bytes (got %d)",
+				ef.Type, extMinLastLength, length)
+		}
+	}
+	return fields, nil, nil
+}
+
+// appendExtensionField encodes a well-formed extension field, padding the
+// body to a multiple of 4 bytes
+func appendExtensionField(b []byte, fieldType ExtensionFieldType, body []byte) []byte {
+	return ExtensionField{Type: fieldType, Value: padBody(body)}.appendTo(b)
+}
+
+// padBody zero-pads a body to a multiple of 4 bytes
+func padBody(body []byte) []byte {
+	if len(body)%4 == 0 {
+		return body
+	}
+	return append(append([]byte(nil), body...), make([]byte, padTo4(len(body))-len(body))...)
+}
+
+// padTo4 rounds n up to a multiple of 4
+func padTo4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// Malformations the injector can apply to an extension field
+const (
+	MalformNone      = ""
+	MalformBadLength = "bad_length" // Length field claims more bytes than follow
+	MalformUnpadded  = "unpadded"   // Body not padded to a multiple of 4
+	MalformTooShort  = "too_short"  // Below the RFC 7822 16-byte minimum
+	MalformZero      = "zero_length"
+)
+
+// Datagram budget for injected fields: the largest UDP payload over IPv4,
+// less the NTP header and the largest MAC (a key ID and a SHA1 digest)
+const (
+	maxDatagramSize = 65507
+	maxMACSize      = 4 + 20
+	maxInjectedSize = maxDatagramSize - ntpPacketSize - maxMACSize
+)
+
+// ExtensionInjection configures one extension field added to responses
+type ExtensionInjection struct {
+	Fraction     float64 `yaml:"fraction"`     // Probability per response
+	Type         uint16  `yaml:"type"`         // Field type; defaults to an unassigned type
+	Size         int     `yaml:"size"`         // Body size in bytes
+	ValueHex     string  `yaml:"value_hex"`    // Body contents (repeated to size); default zeros
+	Malformation string  `yaml:"malformation"` // bad_length, unpadded, too_short or zero_length
+}
+
+// defaultInjectedType is an unassigned field type that clients must ignore
+const defaultInjectedType = 0xf0f0
+
+// ExtensionInjector adds configured extension fields to responses to
+// exercise client parsers
+type ExtensionInjector struct {
+	fields    []ExtensionField
+	fractions []float64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewExtensionInjector builds the injector from the configuration
+func NewExtensionInjector(config *Config) (*ExtensionInjector, error) {
+	injector := &ExtensionInjector{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+	total := 0
+	for i, inj := range config.ExtensionFields.Inject {
+		if inj.Fraction < 0 || inj.Fraction > 1 {
+			return nil, fmt.Errorf("extension injection %d: invalid fraction %g (must be 0-1)", i+1, inj.Fraction)
+		}
+		if inj.Size < 0 || inj.Size > maxInjectedSize {
+			return nil, fmt.Errorf("extension injection %d: invalid size %d (must be 0-%d)", i+1, inj.Size, maxInjectedSize)
+		}
+
+		ef, err := buildInjectedField(inj)
+		if err != nil {
+			return nil, fmt.Errorf("extension injection %d: %w", i+1, err)
+		}
+		if total += 4 + len(ef.Value); total > maxInjectedSize {
+			return nil, fmt.Errorf("extension injections total %d bytes (must be at most %d)", total, maxInjectedSize)
+		}
+		injector.fields = append(injector.fields, ef)
+		injector.fractions = append(injector.fractions, inj.Fraction)
+	}
+
+	return injector, nil
+}
+
+// buildInjectedField creates the field described by an injection rule
+func buildInjectedField(inj ExtensionInjection) (ExtensionField, error) {
+	fieldType := ExtensionFieldType(inj.Type)
+	if fieldType == 0 {
+		fieldType = defaultInjectedType
+	}
+
+	pattern := []byte{0}
+	if inj.ValueHex != "" {
+		var err error
+		if pattern, err = hex.DecodeString(inj.ValueHex); err != nil || len(pattern) == 0 {
+			return ExtensionField{}, fmt.Errorf("invalid value_hex %q", inj.ValueHex)
+		}
+	}
+	size := inj.Size
+	if size == 0 {
+		size = extMinLastLength - 4
+	}
+	body := make([]byte, size)
+	for i := range body {
+		body[i] = pattern[i%len(pattern)]
+	}
+
+	ef := ExtensionField{Type: fieldType, Value: body}
+	switch strings.ToLower(inj.Malformation) {
+	case MalformNone:
+		ef.Value = padBody(body)
+		if ef.EncodedLength() < extMinLength {
+			return ExtensionField{}, fmt.Errorf("size %d is below the %d byte field minimum (use malformation %s)",
+				size, extMinLength, MalformTooShort)
+		}
+	case MalformBadLength:
+		length := uint16(4 + len(body) + 64)
+		ef.Length = &length
+	case MalformUnpadded:
+		if len(body)%4 == 0 {
+			ef.Value = body[:len(body)-1]
+		}
+	case MalformTooShort:
+		ef.Value = body[:min(4, len(body))]
+	case MalformZero:
+		ef.Value = nil
+		ef.Length = new(uint16)
+	default:
+		return ExtensionField{}, fmt.Errorf("unknown malformation %q (must be %s, %s, %s or %s)",
+			inj.Malformation, MalformBadLength, MalformUnpadded, MalformTooShort, MalformZero)
+	}
+	return ef, nil
+}
+
+// Inject appends the fields whose dice roll fires and returns their types.
+// NTS trailers grow with the request, so fields that would push the
+// response, with room left for a MAC, past a datagram are skipped.
+func (x *ExtensionInjector) Inject(response *NTPPacket) []string {
+	if len(x.fields) == 0 {
+		return nil
+	}
+
+	room := maxDatagramSize - maxMACSize - ntpPacketSize
+	for _, ef := range response.Extensions {
+		room -= 4 + len(ef.Value)
+	}
+
+	var injected []string
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for i, ef := range x.fields {
+		if x.rand.Float64() < x.fractions[i] && 4+len(ef.Value) <= room {
+			response.Extensions = append(response.Extensions, ef)
+			injected = append(injected, ef.Type.String())
+			room -= 4 + len(ef.Value)
+		}
+	}
+	return injected
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// field encodes a raw extension field with the given length field
+func field(typ uint16, length int, body []byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(length))
+	return append(b, body...)
+}
+
+func TestParseTrailer(t *testing.T) {
+	uid := field(uint16(ExtUniqueIdentifier), 36, make([]byte, 32))
+	small := field(0x1234, 16, make([]byte, 12))
+	mac := append([]byte{0, 0, 0, 7}, make([]byte, 16)...)
+
+	tests := []struct {
+		name    string
+		trailer []byte
+		version uint8
+		fields  int
+		macID   uint32 // 0 = no MAC
+		wantErr string
+	}{
+		{"empty", nil, 4, 0, 0, ""},
+		{"one field", uid, 4, 1, 0, ""},
+		{"two fields", append(append([]byte(nil), small...), uid...), 4, 2, 0, ""},
+		{"md5 mac", mac, 4, 0, 7, ""},
+		{"sha1 mac", append([]byte{0, 0, 0, 8}, make([]byte, 20)...), 4, 0, 8, ""},
+		{"crypto-nak", []byte{0, 0, 0, 0}, 4, 0, 0, ""},
+		{"field then mac", append(append([]byte(nil), small...), mac...), 4, 1, 7, ""},
+		{"v3 ignores junk", []byte{1, 2, 3, 4, 5, 6, 7, 8}, 3, 0, 0, ""},
+		{"v3 mac", mac, 3, 0, 7, ""},
+		{"truncated", []byte{1, 2, 3, 4, 5, 6, 7, 8}, 4, 0, 0, "truncated"},
+		{"length past end", field(0x1234, 64, make([]byte, 12)), 4, 0, 0, "exceeds"},
+		{"length below header", field(0x1234, 2, make([]byte, 12)), 4, 0, 0, "invalid length"},
+		{"below minimum", append(field(0x1234, 12, make([]byte, 8)), uid...), 4, 0, 0, "minimum"},
+		{"unpadded", append(field(0x1234, 17, make([]byte, 13)), uid[:35]...), 4, 0, 0, "multiple of 4"},
+		{"short last field", small, 4, 0, 0, "last field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fields, mac, err := parseTrailer(tt.trailer, tt.version)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseTrailer error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseTrailer: %v", err)
+			}
+			if len(fields) != tt.fields {
+				t.Errorf("got %d fields, want %d", len(fields), tt.fields)
+			}
+			switch {
+			case tt.macID == 0 && mac != nil && mac.KeyID != 0:
+				t.Errorf("unexpected MAC with key %d", mac.KeyID)
+			case tt.macID != 0 && (mac == nil || mac.KeyID != tt.macID):
+				t.Errorf("MAC = %+v, want key %d", mac, tt.macID)
+			}
+		})
+	}
+}
+
+func TestParseNTPPacketStrict(t *testing.T) {
+	data := (&NTPPacket{Version: 4, Mode: 3}).ToBytes()
+	data = append(data, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+
+	if _, err := ParseNTPPacket(data, true); err == nil {
+		t.Error("strict parsing accepted a malformed trailer")
+	}
+	packet, err := ParseNTPPacket(data, false)
+	if err != nil {
+		t.Fatalf("lenient parsing: %v", err)
+	}
+	if packet.Extensions != nil || packet.MAC != nil {
+		t.Errorf("lenient parsing kept %d fields and MAC %+v", len(packet.Extensions), packet.MAC)
+	}
+}
+
+// newTestInjector builds an injector from rules
+func newTestInjector(rules ...ExtensionInjection) (*ExtensionInjector, error) {
+	config := &Config{}
+	config.ExtensionFields.Inject = rules
+	return NewExtensionInjector(config)
+}
+
+func TestExtensionInjectorRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    ExtensionInjection
+		length  int // Encoded length field
+		value   int // Bytes actually written
+		wantErr string
+	}{
+		{"default size", ExtensionInjection{}, 28, 24, ""},
+		{"padded", ExtensionInjection{Size: 9}, 16, 12, ""},
+		{"undersized", ExtensionInjection{Size: 8}, 0, 0, "below the 16 byte"},
+		{"bad length", ExtensionInjection{Size: 12, Malformation: MalformBadLength}, 80, 12, ""},
+		{"unpadded", ExtensionInjection{Size: 16, Malformation: MalformUnpadded}, 19, 15, ""},
+		{"unpadded odd", ExtensionInjection{Size: 13, Malformation: MalformUnpadded}, 17, 13, ""},
+		{"too short", ExtensionInjection{Size: 40, Malformation: MalformTooShort}, 8, 4, ""},
+		{"too short size 1", ExtensionInjection{Size: 1, Malformation: MalformTooShort}, 5, 1, ""},
+		{"too short size 3", ExtensionInjection{Size: 3, Malformation: MalformTooShort}, 7, 3, ""},
+		{"unpadded size 1", ExtensionInjection{Size: 1, Malformation: MalformUnpadded}, 5, 1, ""},
+		{"zero length", ExtensionInjection{Size: 8, Malformation: MalformZero}, 0, 0, ""},
+		{"unknown malformation", ExtensionInjection{Malformation: "odd"}, 0, 0, "unknown malformation"},
+		{"negative size", ExtensionInjection{Size: -1}, 0, 0, "invalid size"},
+		{"oversized", ExtensionInjection{Size: maxInjectedSize + 1}, 0, 0, "invalid size"},
+		{"bad fraction", ExtensionInjection{Fraction: 1.5}, 0, 0, "invalid fraction"},
+		{"bad hex", ExtensionInjection{ValueHex: "xyz"}, 0, 0, "invalid value_hex"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injector, err := newTestInjector(tt.rule)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewExtensionInjector error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewExtensionInjector: %v", err)
+			}
+			ef := injector.fields[0]
+			if ef.EncodedLength() != tt.length || len(ef.Value) != tt.value {
+				t.Errorf("field length %d with %d byte value, want %d and %d",
+					ef.EncodedLength(), len(ef.Value), tt.length, tt.value)
+			}
+			if tt.rule.Malformation == MalformNone && ef.Validate() != nil {
+				t.Errorf("well-formed rule built an invalid field: %v", ef.Validate())
+			}
+		})
+	}
+}
+
+func TestExtensionInjectorTotalSize(t *testing.T) {
+	half := ExtensionInjection{Fraction: 1, Size: maxInjectedSize / 2}
+	if _, err := newTestInjector(half, half); err == nil || !strings.Contains(err.Error(), "total") {
+		t.Errorf("NewExtensionInjector error = %v, want a total size error", err)
+	}
+	quarter := ExtensionInjection{Fraction: 1, Size: maxInjectedSize / 4}
+	if _, err := newTestInjector(quarter, quarter); err != nil {
+		t.Errorf("NewExtensionInjector: %v", err)
+	}
+}
+
+func TestExtensionInjectorDatagramSize(t *testing.T) {
+	// Rules filling the budget, short of padding, fit with the largest MAC
+	first := ExtensionInjection{Fraction: 1, Size: 32768}
+	rest := ExtensionInjection{Fraction: 1, Size: (maxInjectedSize - 32768 - 8) &^ 3}
+	injector, err := newTestInjector(first, rest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := &NTPPacket{Version: 4, Mode: 4}
+	injector.Inject(response)
+	response.MAC = &MAC{KeyID: 1, Digest: make([]byte, 20)}
+	if size := len(response.ToBytes()); size < maxDatagramSize-3 || size > maxDatagramSize {
+		t.Errorf("response is %d bytes, want just under %d", size, maxDatagramSize)
+	}
+
+	// Fields that do not fit beside an existing trailer are skipped
+	response = &NTPPacket{Version: 4, Mode: 4,
+		Extensions: []ExtensionField{{Type: ExtNTSAuthenticator, Value: make([]byte, 1024)}}}
+	if injected := injector.Inject(response); len(injected) != 1 {
+		t.Errorf("injected %v, want only the first field", injected)
+	}
+	if size := len(response.ToBytes()) + maxMACSize; size > maxDatagramSize {
+		t.Errorf("response is %d bytes with a MAC, want at most %d", size, maxDatagramSize)
+	}
+}
+
+func TestExtensionInjectorRoundTrip(t *testing.T) {
+	injector, err := newTestInjector(
+		ExtensionInjection{Fraction: 1, Type: 0x1234, Size: 40, ValueHex: "deadbeef"},
+		ExtensionInjection{Fraction: 0, Size: 16},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := &NTPPacket{Version: 4, Mode: 4}
+	if injected := injector.Inject(response); len(injected) != 1 || injected[0] != "0x1234" {
+		t.Fatalf("Inject = %v, want [0x1234]", injected)
+	}
+
+	packet, err := ParseNTPPacket(response.ToBytes(), true)
+	if err != nil {
+		t.Fatalf("ParseNTPPacket: %v", err)
+	}
+	if len(packet.Extensions) != 1 {
+		t.Fatalf("got %d fields, want 1", len(packet.Extensions))
+	}
+	ef := packet.Extensions[0]
+	if ef.Type != 0x1234 || !bytes.Equal(ef.Value, bytes.Repeat([]byte{0xde, 0xad, 0xbe, 0xef}, 10)) {
+		t.Errorf("field = %s %x", ef.Type, ef.Value)
+	}
+}
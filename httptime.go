@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTPTimeServer serves manipulated time over HTTP for htpdate-style
+// clients: every path answers with a Date header and a JSON body holding
+// the requesting client's manipulated time
+type HTTPTimeServer struct {
+	server     *NTPServer
+	httpServer *http.Server
+}
+
+// httpTimeView is the JSON body of an HTTP time response
+type httpTimeView struct {
+	Time   string  `json:"time"`    // RFC 3339, nanoseconds
+	Unix   float64 `json:"unix"`    // Seconds since the Unix epoch
+	UnixMs int64   `json:"unix_ms"` // Milliseconds since the Unix epoch
+}
+
+// NewHTTPTimeServer creates the HTTP time listener
+func NewHTTPTimeServer(config *Config, server *NTPServer) *HTTPTimeServer {
+	h := &HTTPTimeServer{server: server}
+	h.httpServer = &http.Server{
+		Addr:              net.JoinHostPort(config.HTTPTime.Host, strconv.Itoa(config.HTTPTime.Port)),
+		Handler:           http.HandlerFunc(h.handle),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return h
+}
+
+// Start serves HTTP time until Stop is called
+func (h *HTTPTimeServer) Start() error {
+	LogInfo("HTTP time source listening on %s", h.httpServer.Addr)
+
+	err := h.httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Stop shuts down the HTTP time listener
+func (h *HTTPTimeServer) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return h.httpServer.Shutdown(ctx)
+}
+
+// handle answers any request with the client's manipulated time
+func (h *HTTPTimeServer) handle(w http.ResponseWriter, r *http.Request) {
+	rxTime := time.Now()
+	s := h.server
+	p := s.policies.Load()
+
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
+	ip := net.ParseIP(host)
+	if err != nil || ip == nil {
+		http.Error(w, "bad remote address", http.StatusBadRequest)
+		return
+	}
+	portNum, _ := strconv.Atoi(port)
+	addr := &net.UDPAddr{IP: ip, Port: portNum}
+
+	if !s.admit(p, addr, "http") {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	clientKey := p.keyer.KeyForHost(addr.IP)
+	manipulatedTime, offset, isInitial := s.tracker.GetManipulatedTime(clientKey, addr.IP, rxTime)
+	servedTime, _ := p.leap.Apply(manipulatedTime)
+
+	// net/http only adds its own Date header when none is set
+	w.Header().Set("Date", servedTime.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, httpTimeView{
+		Time:   servedTime.UTC().Format(time.RFC3339Nano),
+		Unix:   float64(servedTime.UnixNano()) / 1e9,
+		UnixMs: servedTime.UnixMilli(),
+	})
+
+	s.metrics.RecordResponse(OutcomeServed, "http")
+	if p.config.Logging.LogTransactions {
+		h.logRequest(r.Method, addr, offset, isInitial, manipulatedTime)
+	}
+}
+
+// logRequest logs an HTTP time transaction
+func (h *HTTPTimeServer) logRequest(method string, addr *net.UDPAddr, offset float64, isInitial bool, manipulatedTime time.Time) {
+	log := TransactionLog{
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Event:       "http_request",
+		RequestType: method,
+	}
+
+	log.Client.IP = addr.IP.String()
+	log.Client.Port = addr.Port
+	log.Client.IsNew = isInitial
+
+	log.Response.ActualTime = time.Now().UTC().Format(time.RFC3339Nano)
+	log.Response.OffsetSeconds = offset
+	log.Response.OffsetMinutes = offset / 60.0
+	log.Response.ManipulatedTime = manipulatedTime.UTC().Format(time.RFC3339Nano)
+
+	manipulation := h.server.tracker.Config().TimeManipulation
+	log.Config.NMinutes = manipulation.InitialOffsetMinutes
+	log.Config.XSeconds = manipulation.JitterSeconds
+	log.Config.Stratum = h.server.Config().NTP.Stratum
+
+	h.server.writeTransaction(&log, fmt.Sprintf("http %s request from %s - offset: %.1f sec (%.2f min)",
+		method, addr.String(), log.Response.OffsetSeconds, log.Response.OffsetMinutes))
+}
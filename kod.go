@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// kodClientRule sends a fixed KoD code to every client in a network
+type kodClientRule struct {
+	network *net.IPNet
+	code    string
+}
+
+// KoDPolicy decides which requests are answered with a Kiss-o'-Death
+// packet instead of the time
+type KoDPolicy struct {
+	enabled  bool
+	code     string
+	fraction float64
+	clients  []kodClientRule
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewKoDPolicy builds the KoD chaos policy from the configuration
+func NewKoDPolicy(config *Config) (*KoDPolicy, error) {
+	kod := config.KoD
+	policy := &KoDPolicy{
+		enabled:  kod.Enabled,
+		code:     strings.ToUpper(kod.Code),
+		fraction: kod.Fraction,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	if err := ValidateKoDCode(policy.code); err != nil {
+		return nil, err
+	}
+	if kod.Fraction < 0 || kod.Fraction > 1 {
+		return nil, fmt.Errorf("invalid KoD fraction: %g (must be 0-1)", kod.Fraction)
+	}
+
+	for _, client := range kod.Clients {
+		network, err := ParseNetwork(client.CIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid KoD client rule: %w", err)
+		}
+
+		code := policy.code
+		if client.Code != "" {
+			code = strings.ToUpper(client.Code)
+			if err := ValidateKoDCode(code); err != nil {
+				return nil, err
+			}
+		}
+
+		policy.clients = append(policy.clients, kodClientRule{network: network, code: code})
+	}
+
+	return policy, nil
+}
+
+// ValidateKoDCode checks that a kiss code fits in the 4-byte reference ID.
+// Codes outside RFC 5905 are allowed so client parsers can be exercised.
+func ValidateKoDCode(code string) error {
+	if len(code) == 0 || len(code) > 4 {
+		return fmt.Errorf("invalid KoD code %q (must be 1-4 ASCII characters)", code)
+	}
+	for _, c := range code {
+		if c < 0x20 || c > 0x7e {
+			return fmt.Errorf("invalid KoD code %q (must be printable ASCII)", code)
+		}
+	}
+	return nil
+}
+
+// Decide returns the kiss code to send to a client, if any, and the reason
+func (p *KoDPolicy) Decide(ip net.IP) (string, string, bool) {
+	if !p.enabled {
+		return "", "", false
+	}
+
+	for _, rule := range p.clients {
+		if rule.network.Contains(ip) {
+			return rule.code, "client_rule", true
+		}
+	}
+
+	if p.fraction > 0 {
+		p.mu.Lock()
+		hit := p.rand.Float64() < p.fraction
+		p.mu.Unlock()
+		if hit {
+			return p.code, "chaos", true
+		}
+	}
+
+	return "", "", false
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Leap indicator values (RFC 5905 figure 9)
+const (
+	LeapNone   = 0 // No warning
+	LeapInsert = 1 // Last minute of the day has 61 seconds
+	LeapDelete = 2 // Last minute of the day has 59 seconds
+	// leapAlarm (3) = clock unsynchronized, defined in ntp.go
+)
+
+// Leap second handling modes
+const (
+	LeapModeStep   = "step"   // Jump the clock by one second at the event
+	LeapModeRepeat = "repeat" // Hold the clock still for the extra second
+	LeapModeSmear  = "smear"  // Spread the second linearly over a window
+)
+
+// LeapSimulator applies a scheduled fake leap second to manipulated time
+// and decides which leap indicator to advertise
+type LeapSimulator struct {
+	indicator uint8
+
+	enabled  bool
+	event    time.Time // Midnight UTC at the end of the leap day
+	insert   bool
+	mode     string
+	announce time.Duration
+	window   time.Duration
+}
+
+// NewLeapSimulator builds the leap second simulator from the configuration
+func NewLeapSimulator(config *Config) (*LeapSimulator, error) {
+	leap := config.Leap
+	if leap.Indicator < 0 || leap.Indicator > 3 {
+		return nil, fmt.Errorf("invalid leap indicator: %d (must be 0-3)", leap.Indicator)
+	}
+
+	sim := &LeapSimulator{
+		indicator: uint8(leap.Indicator),
+		enabled:   leap.Event.Enabled,
+		mode:      strings.ToLower(leap.Event.Mode),
+		announce:  time.Duration(leap.Event.AnnounceSeconds) * time.Second,
+		window:    time.Duration(leap.Event.SmearWindowSeconds) * time.Second,
+	}
+	if !sim.enabled {
+		return sim, nil
+	}
+
+	event, err := time.Parse(time.RFC3339, leap.Event.Time)
+	if err != nil {
+		return nil, fmt.Errorf("invalid leap event time %q: %w", leap.Event.Time, err)
+	}
+	sim.event = event
+
+	switch strings.ToLower(leap.Event.Type) {
+	case "insert":
+		sim.insert = true
+	case "delete":
+		sim.insert = false
+	default:
+		return nil, fmt.Errorf("invalid leap event type %q (must be insert or delete)", leap.Event.Type)
+	}
+
+	switch sim.mode {
+	case LeapModeStep, LeapModeRepeat:
+	case LeapModeSmear:
+		if sim.window <= 0 {
+			return nil, fmt.Errorf("invalid leap smear window: %d seconds (must be positive)", leap.Event.SmearWindowSeconds)
+		}
+	default:
+		return nil, fmt.Errorf("invalid leap mode %q (must be %s, %s or %s)",
+			leap.Event.Mode, LeapModeStep, LeapModeRepeat, LeapModeSmear)
+	}
+
+	if sim.announce < 0 {
+		return nil, fmt.Errorf("invalid leap announce period: %d seconds (must not be negative)", leap.Event.AnnounceSeconds)
+	}
+
+	return sim, nil
+}
+
+// Apply transforms a manipulated time according to the leap second event
+// and returns the time to serve along with the leap indicator
+func (l *LeapSimulator) Apply(t time.Time) (time.Time, uint8) {
+	if !l.enabled {
+		return t, l.indicator
+	}
+
+	served, li := l.applyEvent(t)
+	if l.indicator == leapAlarm {
+		li = leapAlarm
+	}
+	return served, li
+}
+
+// applyEvent shifts t across the leap second and picks the announcement
+func (l *LeapSimulator) applyEvent(t time.Time) (time.Time, uint8) {
+	sign := time.Duration(-1) // Inserted second: the clock falls back
+	li := uint8(LeapInsert)
+	if !l.insert {
+		sign = 1
+		li = LeapDelete
+	}
+
+	if l.mode == LeapModeSmear {
+		// Smears are not announced; the second is absorbed over a window
+		// centred on the event
+		start := l.event.Add(-l.window / 2)
+		switch {
+		case t.Before(start):
+			return t, l.indicator
+		case t.Before(start.Add(l.window)):
+			fraction := float64(t.Sub(start)) / float64(l.window)
+			return t.Add(sign * time.Duration(fraction*float64(time.Second))), l.indicator
+		default:
+			return t.Add(sign * time.Second), l.indicator
+		}
+	}
+
+	// A deleted second is skipped one second before midnight
+	boundary := l.event
+	if !l.insert {
+		boundary = l.event.Add(-time.Second)
+	}
+
+	if t.Before(boundary) {
+		if !t.Before(boundary.Add(-l.announce)) {
+			return t, li
+		}
+		return t, l.indicator
+	}
+
+	if l.insert && l.mode == LeapModeRepeat && t.Before(boundary.Add(time.Second)) {
+		// Hold just before midnight for the inserted second
+		return boundary.Add(-time.Nanosecond), l.indicator
+	}
+
+	return t.Add(sign * time.Second), l.indicator
+}
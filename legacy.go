@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Legacy time protocols
+const (
+	ProtocolTime    = "time"    // RFC 868: 32-bit seconds since 1900
+	ProtocolDaytime = "daytime" // RFC 867: human-readable date and time
+)
+
+// legacyTimeout bounds how long a TCP client may hold a connection
+const legacyTimeout = 10 * time.Second
+
+// LegacyServer serves the TIME and DAYTIME protocols over TCP and UDP.
+// Requests share the NTP path's per-client timelines, so a host sees the
+// same manipulated clock on every protocol.
+type LegacyServer struct {
+	server *NTPServer
+	config *Config
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     []*net.UDPConn
+}
+
+// NewLegacyServer creates the TIME/DAYTIME server
+func NewLegacyServer(config *Config, server *NTPServer) *LegacyServer {
+	return &LegacyServer{config: config, server: server}
+}
+
+// validateLegacy checks the legacy protocol settings
+func validateLegacy(config *Config) error {
+	lc := config.Legacy
+	for _, service := range []struct {
+		name     string
+		enabled  bool
+		port     int
+		tcp, udp bool
+	}{
+		{ProtocolTime, lc.Time.Enabled, lc.Time.Port, lc.Time.TCP, lc.Time.UDP},
+		{ProtocolDaytime, lc.Daytime.Enabled, lc.Daytime.Port, lc.Daytime.TCP, lc.Daytime.UDP},
+	} {
+		if !service.enabled {
+			continue
+		}
+		if service.port < 1 || service.port > 65535 {
+			return fmt.Errorf("invalid %s port: %d (must be 1-65535)", service.name, service.port)
+		}
+		if !service.tcp && !service.udp {
+			return fmt.Errorf("%s is enabled but neither tcp nor udp is", service.name)
+		}
+	}
+	return nil
+}
+
+// Start opens the enabled listeners and serves them in the background
+func (l *LegacyServer) Start() error {
+	lc := l.config.Legacy
+	if lc.Time.Enabled {
+		if err := l.listen(ProtocolTime, lc.Time.Port, lc.Time.TCP, lc.Time.UDP); err != nil {
+			l.Stop()
+			return err
+		}
+	}
+	if lc.Daytime.Enabled {
+		if err := l.listen(ProtocolDaytime, lc.Daytime.Port, lc.Daytime.TCP, lc.Daytime.UDP); err != nil {
+			l.Stop()
+			return err
+		}
+	}
+	return nil
+}
+
+// listen opens the TCP and/or UDP sockets of one protocol
+func (l *LegacyServer) listen(protocol string, port int, tcp, udp bool) error {
+	addr := net.JoinHostPort(l.config.Legacy.Host, strconv.Itoa(port))
+
+	if tcp {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for %s on tcp %s: %w", protocol, addr, err)
+		}
+		l.mu.Lock()
+		l.listeners = append(l.listeners, listener)
+		l.mu.Unlock()
+		go l.serveTCP(protocol, listener)
+	}
+
+	if udp {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s address %s: %w", protocol, addr, err)
+		}
+		conn, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for %s on udp %s: %w", protocol, addr, err)
+		}
+		l.mu.Lock()
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+		go l.serveUDP(protocol, conn)
+	}
+
+	LogInfo("%s listening on %s (tcp: %t, udp: %t)", strings.ToUpper(protocol), addr, tcp, udp)
+	return nil
+}
+
+// Stop closes all listeners
+func (l *LegacyServer) Stop() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, listener := range l.listeners {
+		listener.Close()
+	}
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// serveTCP answers each connection with the time and closes it
+func (l *LegacyServer) serveTCP(protocol string, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			LogWarning("%s accept failed: %v", protocol, err)
+			continue
+		}
+
+		go func() {
+			defer conn.Close()
+			tcpAddr := conn.RemoteAddr().(*net.TCPAddr)
+			payload, ok := l.respond(protocol, "tcp", &net.UDPAddr{IP: tcpAddr.IP, Port: tcpAddr.Port, Zone: tcpAddr.Zone})
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(legacyTimeout))
+			if _, err := conn.Write(payload); err != nil {
+				LogDebug("Error sending %s to %s: %v", protocol, tcpAddr.String(), err)
+			}
+		}()
+	}
+}
+
+// serveUDP answers every datagram with the time
+func (l *LegacyServer) serveUDP(protocol string, conn *net.UDPConn) {
+	buf := make([]byte, 512)
+	for {
+		_, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			LogWarning("%s read failed: %v", protocol, err)
+			continue
+		}
+
+		if payload, ok := l.respond(protocol, "udp", addr); ok {
+			if _, err := conn.WriteToUDP(payload, addr); err != nil {
+				LogDebug("Error sending %s to %s: %v", protocol, addr.String(), err)
+			}
+		}
+	}
+}
+
+// respond applies the access list and rate limit, then encodes the
+// client's manipulated time. ok is false if the request must be dropped.
+func (l *LegacyServer) respond(protocol, transport string, addr *net.UDPAddr) ([]byte, bool) {
+	s := l.server
+	p := s.policies.Load()
+	reason := protocol + "_" + transport
+
+	if !s.admit(p, addr, protocol) {
+		return nil, false
+	}
+
+	rxTime := time.Now()
+	clientKey := p.keyer.KeyForHost(addr.IP)
+	manipulatedTime, offset, isInitial := s.tracker.GetManipulatedTime(clientKey, addr.IP, rxTime)
+	servedTime, _ := p.leap.Apply(manipulatedTime)
+
+	var payload []byte
+	switch protocol {
+	case ProtocolTime:
+		payload = binary.BigEndian.AppendUint32(nil, uint32(servedTime.Unix()+ntpEpochOffset))
+	case ProtocolDaytime:
+		payload = []byte(servedTime.UTC().Format(time.ANSIC) + "\r\n")
+	}
+
+	s.metrics.RecordResponse(OutcomeServed, reason)
+	if p.config.Logging.LogTransactions {
+		l.logRequest(protocol, transport, addr, offset, isInitial, manipulatedTime)
+	}
+	return payload, true
+}
+
+// logRequest logs a TIME or DAYTIME transaction
+func (l *LegacyServer) logRequest(protocol, transport string, addr *net.UDPAddr, offset float64, isInitial bool, manipulatedTime time.Time) {
+	log := TransactionLog{
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Event:       protocol + "_request",
+		RequestType: transport,
+	}
+
+	log.Client.IP = addr.IP.String()
+	log.Client.Port = addr.Port
+	log.Client.IsNew = isInitial
+
+	log.Response.ActualTime = time.Now().UTC().Format(time.RFC3339Nano)
+	log.Response.OffsetSeconds = offset
+	log.Response.OffsetMinutes = offset / 60.0
+	log.Response.ManipulatedTime = manipulatedTime.UTC().Format(time.RFC3339Nano)
+
+	manipulation := l.server.tracker.Config().TimeManipulation
+	log.Config.NMinutes = manipulation.InitialOffsetMinutes
+	log.Config.XSeconds = manipulation.JitterSeconds
+	log.Config.Stratum = l.server.Config().NTP.Stratum
+
+	l.server.writeTransaction(&log, fmt.Sprintf("%s/%s request from %s - offset: %.1f sec (%.2f min)",
+		protocol, transport, addr.String(), log.Response.OffsetSeconds, log.Response.OffsetMinutes))
+}
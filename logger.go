@@ -1,32 +1,247 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"time"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
 )
 
-// Simple logging functions
+// Log sinks
+const (
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+	OutputFile   = "file"
+)
+
+// Logging state. Diagnostics and transactions use separate sinks so the
+// transaction stream stays machine-parseable.
+var (
+	logMu        sync.RWMutex
+	diagLogger   = slog.New(newLineHandler(os.Stderr, slog.LevelInfo))
+	diagSink     io.WriteCloser
+	txWriter     io.Writer = os.Stdout
+	txSink       io.WriteCloser
+	txWriteMutex sync.Mutex
+)
+
+// ConfigureLogging (re)opens the log sinks and applies the configured
+// level. Sinks from a previous call are closed once the new ones are live.
+func ConfigureLogging(config *Config) error {
+	level, err := ParseLogLevel(config.Logging.Level)
+	if err != nil {
+		return err
+	}
+
+	lc := config.Logging
+	diagOut, diagCloser, err := openLogSink(lc.Output, lc.File, config)
+	if err != nil {
+		return fmt.Errorf("error opening diagnostic log: %w", err)
+	}
+
+	txOut, txCloser, err := openLogSink(lc.TransactionOutput, lc.TransactionFile, config)
+	if err != nil {
+		if diagCloser != nil {
+			diagCloser.Close()
+		}
+		return fmt.Errorf("error opening transaction log: %w", err)
+	}
+
+	var handler slog.Handler
+	if strings.ToLower(lc.DiagnosticFormat) == "json" {
+		handler = slog.NewJSONHandler(diagOut, &slog.HandlerOptions{Level: level})
+	} else {
+		handler = newLineHandler(diagOut, level)
+	}
+
+	logMu.Lock()
+	oldDiag, oldTx := diagSink, txSink
+	diagLogger = slog.New(handler)
+	diagSink = diagCloser
+	txWriteMutex.Lock()
+	txWriter = txOut
+	txSink = txCloser
+	txWriteMutex.Unlock()
+	logMu.Unlock()
+
+	if oldDiag != nil {
+		oldDiag.Close()
+	}
+	if oldTx != nil {
+		oldTx.Close()
+	}
+
+	return nil
+}
+
+// CloseLogging flushes and closes any file sinks
+func CloseLogging() {
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	if diagSink != nil {
+		diagSink.Close()
+		diagSink = nil
+	}
+	diagLogger = slog.New(newLineHandler(os.Stderr, slog.LevelInfo))
+
+	txWriteMutex.Lock()
+	if txSink != nil {
+		txSink.Close()
+		txSink = nil
+	}
+	txWriter = os.Stdout
+	txWriteMutex.Unlock()
+}
+
+// ParseLogLevel converts a configured level name to a slog level
+func ParseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToUpper(level) {
+	case "DEBUG":
+		return slog.LevelDebug, nil
+	case "", "INFO":
+		return slog.LevelInfo, nil
+	case "WARN", "WARNING":
+		return slog.LevelWarn, nil
+	case "ERROR":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("invalid log level %q (must be DEBUG, INFO, WARNING or ERROR)", level)
+}
+
+// validateLogOutput checks an output name and its file path
+func validateLogOutput(output, path string) error {
+	switch strings.ToLower(output) {
+	case OutputStdout, OutputStderr:
+		return nil
+	case OutputFile:
+		if path == "" {
+			return fmt.Errorf("log output is %q but no file path is set", output)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid log output %q (must be %s, %s or %s)", output, OutputStdout, OutputStderr, OutputFile)
+}
+
+// openLogSink returns the writer for an output and, for files, its closer
+func openLogSink(output, path string, config *Config) (io.Writer, io.WriteCloser, error) {
+	switch strings.ToLower(output) {
+	case OutputStdout:
+		return os.Stdout, nil, nil
+	case OutputStderr:
+		return os.Stderr, nil, nil
+	case OutputFile:
+		rotation := config.Logging.Rotation
+		file, err := NewRotatingFile(path, rotation.MaxSizeMB, rotation.MaxAgeHours, rotation.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		return file, file, nil
+	}
+	return nil, nil, validateLogOutput(output, path)
+}
+
+// WriteTransaction writes one line to the transaction log
+func WriteTransaction(line string) {
+	txWriteMutex.Lock()
+	defer txWriteMutex.Unlock()
+
+	io.WriteString(txWriter, line+"\n")
+}
+
+// logger returns the current diagnostic logger
+func logger() *slog.Logger {
+	logMu.RLock()
+	defer logMu.RUnlock()
+
+	return diagLogger
+}
 
 func LogInfo(format string, args ...interface{}) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	msg := fmt.Sprintf(format, args...)
-	fmt.Printf("[%s] INFO: %s\n", timestamp, msg)
+	logAt(slog.LevelInfo, format, args...)
 }
 
 func LogWarning(format string, args ...interface{}) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	msg := fmt.Sprintf(format, args...)
-	fmt.Printf("[%s] WARN: %s\n", timestamp, msg)
+	logAt(slog.LevelWarn, format, args...)
 }
 
 func LogError(format string, args ...interface{}) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	msg := fmt.Sprintf(format, args...)
-	fmt.Printf("[%s] ERROR: %s\n", timestamp, msg)
+	logAt(slog.LevelError, format, args...)
 }
 
 func LogDebug(format string, args ...interface{}) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	msg := fmt.Sprintf(format, args...)
-	fmt.Printf("[%s] DEBUG: %s\n", timestamp, msg)
+	logAt(slog.LevelDebug, format, args...)
+}
+
+// logAt formats and logs a message if the level is enabled
+func logAt(level slog.Level, format string, args ...interface{}) {
+	l := logger()
+	ctx := context.Background()
+	if !l.Enabled(ctx, level) {
+		return
+	}
+	l.Log(ctx, level, fmt.Sprintf(format, args...))
+}
+
+// lineHandler is a slog handler producing the classic
+// "[2006-01-02 15:04:05] INFO: message" lines
+type lineHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+}
+
+func newLineHandler(w io.Writer, level slog.Level) *lineHandler {
+	return &lineHandler{mu: &sync.Mutex{}, w: w, level: level}
+}
+
+func (h *lineHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *lineHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s: %s", r.Time.Format("2006-01-02 15:04:05"), levelName(r.Level), r.Message)
+
+	appendAttr := func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		appendAttr(a)
+	}
+	r.Attrs(appendAttr)
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *lineHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *lineHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// levelName returns the label used in text diagnostic lines
+func levelName(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "ERROR"
+	case level >= slog.LevelWarn:
+		return "WARN"
+	case level >= slog.LevelInfo:
+		return "INFO"
+	}
+	return "DEBUG"
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is appended to rotated log file names
+const backupTimeFormat = "20060102-150405.000"
+
+// RotatingFile is a log file that rotates when it exceeds a size or age
+// limit, keeping a bounded number of timestamped backups
+type RotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64         // 0 = no size limit
+	maxAge     time.Duration // 0 = no age limit
+	maxBackups int           // 0 = keep all backups
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	closed   bool // Set by Close; writes are refused from then on
+	failing  bool // A rotation or reopen failure has been reported
+}
+
+// NewRotatingFile opens (or creates) a log file with rotation limits
+func NewRotatingFile(path string, maxSizeMB, maxAgeHours, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxAge:     time.Duration(maxAgeHours) * time.Hour,
+		maxBackups: maxBackups,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write appends to the file, rotating first if the write would cross a limit
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+
+	// A failed rotation may have left no file open; keep trying to reopen
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			r.reportFailure(err)
+			return 0, err
+		}
+	}
+
+	if r.needsRotation(int64(len(p))) {
+		if err := r.rotate(); err != nil && r.file == nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// open opens the log file for appending
+func (r *RotatingFile) open() error {
+	if dir := filepath.Dir(r.path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+	r.openedAt = info.ModTime()
+	if r.size == 0 {
+		r.openedAt = time.Now()
+	}
+	return nil
+}
+
+// needsRotation reports whether writing n more bytes crosses a limit
+func (r *RotatingFile) needsRotation(n int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.maxSize > 0 && r.size+n > r.maxSize {
+		return true
+	}
+	return r.maxAge > 0 && time.Since(r.openedAt) > r.maxAge
+}
+
+// rotate renames the current file to a timestamped backup and starts a new
+// one. If that fails the log carries on in r.path, appending to whatever is
+// there, and rotation is retried on the next write. The caller must hold
+// r.mu.
+func (r *RotatingFile) rotate() error {
+	r.file.Close()
+	r.file = nil
+
+	backup := fmt.Sprintf("%s.%s", r.path, time.Now().Format(backupTimeFormat))
+	err := os.Rename(r.path, backup)
+	if openErr := r.open(); err == nil {
+		err = openErr
+	}
+	if err != nil {
+		r.reportFailure(err)
+		return err
+	}
+
+	r.failing = false
+	r.pruneBackups()
+	return nil
+}
+
+// reportFailure prints the first of a run of rotation failures to stderr;
+// the log itself may be what is failing. The caller must hold r.mu.
+func (r *RotatingFile) reportFailure(err error) {
+	if r.failing {
+		return
+	}
+	r.failing = true
+	fmt.Fprintf(os.Stderr, "Error rotating log file %s: %v\n", r.path, err)
+}
+
+// pruneBackups deletes the oldest backups beyond maxBackups
+func (r *RotatingFile) pruneBackups() {
+	if r.maxBackups <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return
+	}
+
+	var backups []string
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, r.path+".")
+		if _, err := time.Parse(backupTimeFormat, suffix); err == nil {
+			backups = append(backups, match)
+		}
+	}
+
+	// Timestamp suffixes sort chronologically
+	sort.Strings(backups)
+	for len(backups) > r.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
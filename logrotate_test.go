@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A rotation that cannot rename the file keeps logging to the same path
+func TestRotatingFileSurvivesFailedRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chaosntpd.log")
+	r, err := NewRotatingFile(path, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.maxSize = 16
+
+	if _, err := r.Write([]byte("first line\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing the file makes the rename fail
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("second line\n")); err != nil {
+		t.Fatalf("write after failed rotation: %v", err)
+	}
+	if _, err := r.Write([]byte("third line\n")); err != nil {
+		t.Fatalf("write after rotation: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "third line") {
+		t.Errorf("log holds %q, want the latest line", data)
+	}
+
+	r.Close()
+	if _, err := r.Write([]byte("late\n")); err != os.ErrClosed {
+		t.Errorf("write after Close = %v, want os.ErrClosed", err)
+	}
+}
@@ -18,26 +18,164 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Set up log levels and sinks
+	if err := ConfigureLogging(config); err != nil {
+		fmt.Fprintf(os.Stderr, "Error configuring logging: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Print startup banner
 	PrintStartupBanner(config)
 
 	// Create and start server
-	server := NewNTPServer(config)
+	server, err := NewNTPServer(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating server: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Start optional admin API
+	var admin *AdminServer
+	if config.Admin.Enabled {
+		admin = NewAdminServer(config, server)
+		go func() {
+			if err := admin.Start(); err != nil {
+				LogError("Admin API failed: %v", err)
+			}
+		}()
+	}
+
+	// Start optional Prometheus endpoint
+	var metrics *MetricsServer
+	if config.Metrics.Enabled {
+		metrics = NewMetricsServer(config, server)
+		go func() {
+			if err := metrics.Start(); err != nil {
+				LogError("Metrics endpoint failed: %v", err)
+			}
+		}()
+	}
+
+	// Start optional NTS key establishment
+	var ntske *NTSKEServer
+	if config.NTS.Enabled {
+		ntske = NewNTSKEServer(config, server)
+		go func() {
+			if err := ntske.Start(); err != nil {
+				LogError("NTS-KE failed: %v", err)
+			}
+		}()
+	}
+
+	// Start optional broadcast/multicast sender
+	var broadcaster *Broadcaster
+	if config.Broadcast.Enabled {
+		broadcaster, err = NewBroadcaster(config, server)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating broadcaster: %v\n", err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := broadcaster.Start(); err != nil {
+				LogError("Broadcast failed: %v", err)
+			}
+		}()
+	}
+
+	// Start optional TIME/DAYTIME listeners
+	var legacy *LegacyServer
+	if config.Legacy.Time.Enabled || config.Legacy.Daytime.Enabled {
+		legacy = NewLegacyServer(config, server)
+		if err := legacy.Start(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting legacy time protocols: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Start optional Roughtime responder
+	var roughtime *RoughtimeServer
+	if config.Roughtime.Enabled {
+		roughtime, err = NewRoughtimeServer(config, server)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating Roughtime server: %v\n", err)
+			os.Exit(1)
+		}
+		go func() {
+			if err := roughtime.Start(); err != nil {
+				LogError("Roughtime failed: %v", err)
+			}
+		}()
+	}
+
+	// Start optional HTTP Date-header time source
+	var httpTime *HTTPTimeServer
+	if config.HTTPTime.Enabled {
+		httpTime = NewHTTPTimeServer(config, server)
+		go func() {
+			if err := httpTime.Start(); err != nil {
+				LogError("HTTP time source failed: %v", err)
+			}
+		}()
+	}
+
+	// Reload the configuration on SIGHUP, keeping the old one on error
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			LogInfo("Received SIGHUP, reloading %s", flags.ConfigPath)
+			updated, err := LoadConfig(flags.ConfigPath, flags)
+			if err == nil {
+				err = server.Reload(updated)
+			}
+			if err != nil {
+				LogError("Configuration reload failed, keeping previous configuration: %v", err)
+				continue
+			}
+			LogInfo("Configuration reloaded")
+		}
+	}()
 
 	// Handle graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
+	shutdownDone := make(chan struct{})
 	go func() {
 		<-sigChan
-		fmt.Println("\n\nShutting down ChaosNTPd...")
+		fmt.Fprintln(os.Stderr, "\n\nShutting down ChaosNTPd...")
+		if admin != nil {
+			admin.Stop()
+		}
+		if metrics != nil {
+			metrics.Stop()
+		}
+		if ntske != nil {
+			ntske.Stop()
+		}
+		if broadcaster != nil {
+			broadcaster.Stop()
+		}
+		if legacy != nil {
+			legacy.Stop()
+		}
+		if roughtime != nil {
+			roughtime.Stop()
+		}
+		if httpTime != nil {
+			httpTime.Stop()
+		}
 		server.Stop()
-		os.Exit(0)
+		CloseLogging()
+		close(shutdownDone)
 	}()
 
-	// Start server (blocking)
+	// Start server (blocking until Stop closes the socket)
 	if err := server.Start(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error starting server: %v\n", err)
 		os.Exit(1)
 	}
+
+	// Let the shutdown handler finish saving state and closing logs
+	<-shutdownDone
 }
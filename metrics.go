@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response outcomes recorded by Metrics
+const (
+	OutcomeServed    = "served"
+	OutcomeDropped   = "dropped"
+	OutcomeKoD       = "kod"
+	OutcomeBroadcast = "broadcast" // Unsolicited mode 5 packets
+	OutcomePeerPoll  = "peer_poll" // Symmetric active polls of configured peers
+)
+
+// Histogram bucket upper bounds
+var (
+	offsetBuckets     = []float64{-3600, -1800, -600, -300, -60, -10, -1, 0, 1, 10, 60, 300, 600, 1800, 3600}
+	processingBuckets = []float64{0.00001, 0.000025, 0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.1}
+)
+
+// histogram is a cumulative Prometheus-style histogram
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Metrics collects request counters and histograms for the /metrics endpoint
+type Metrics struct {
+	mu         sync.Mutex
+	requests   map[[2]int]uint64    // mode, version
+	responses  map[[2]string]uint64 // outcome, reason
+	offsets    *histogram
+	processing *histogram
+	startTime  time.Time
+}
+
+// NewMetrics creates an empty metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:   make(map[[2]int]uint64),
+		responses:  make(map[[2]string]uint64),
+		offsets:    newHistogram(offsetBuckets),
+		processing: newHistogram(processingBuckets),
+		startTime:  time.Now(),
+	}
+}
+
+// RecordRequest counts a parsed request
+func (m *Metrics) RecordRequest(mode, version uint8) {
+	m.mu.Lock()
+	m.requests[[2]int{int(mode), int(version)}]++
+	m.mu.Unlock()
+}
+
+// RecordResponse counts the outcome of a request
+func (m *Metrics) RecordResponse(outcome, reason string) {
+	m.mu.Lock()
+	m.responses[[2]string{outcome, reason}]++
+	m.mu.Unlock()
+}
+
+// RecordServed counts a served response with its offset and processing time
+func (m *Metrics) RecordServed(offsetSeconds float64, processing time.Duration) {
+	m.mu.Lock()
+	m.responses[[2]string{OutcomeServed, "ok"}]++
+	m.offsets.observe(offsetSeconds)
+	m.processing.observe(processing.Seconds())
+	m.mu.Unlock()
+}
+
+// WritePrometheus writes all metrics in the Prometheus text format. The
+// output is rendered first so a slow scraper never holds up the workers.
+func (m *Metrics) WritePrometheus(w io.Writer, server *NTPServer) {
+	var buf bytes.Buffer
+	m.writeCounters(&buf)
+	writeServerStats(&buf, server)
+	w.Write(buf.Bytes())
+}
+
+// writeCounters renders the request counters and histograms
+func (m *Metrics) writeCounters(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(w, "chaosntpd_requests_total", "counter", "NTP requests received, by mode and version.")
+	requestKeys := make([][2]int, 0, len(m.requests))
+	for key := range m.requests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		if requestKeys[i][0] != requestKeys[j][0] {
+			return requestKeys[i][0] < requestKeys[j][0]
+		}
+		return requestKeys[i][1] < requestKeys[j][1]
+	})
+	for _, key := range requestKeys {
+		fmt.Fprintf(w, "chaosntpd_requests_total{mode=\"%d\",version=\"%d\"} %d\n", key[0], key[1], m.requests[key])
+	}
+
+	writeHeader(w, "chaosntpd_responses_total", "counter", "Request outcomes (served, dropped, kod), broadcasts and peer polls, by reason.")
+	responseKeys := make([][2]string, 0, len(m.responses))
+	for key := range m.responses {
+		responseKeys = append(responseKeys, key)
+	}
+	sort.Slice(responseKeys, func(i, j int) bool {
+		if responseKeys[i][0] != responseKeys[j][0] {
+			return responseKeys[i][0] < responseKeys[j][0]
+		}
+		return responseKeys[i][1] < responseKeys[j][1]
+	})
+	for _, key := range responseKeys {
+		fmt.Fprintf(w, "chaosntpd_responses_total{outcome=%q,reason=%q} %d\n", key[0], key[1], m.responses[key])
+	}
+
+	writeHistogram(w, "chaosntpd_applied_offset_seconds", "Offset from real time applied to served responses.", m.offsets)
+	writeHistogram(w, "chaosntpd_processing_seconds", "Time from packet arrival to response transmission.", m.processing)
+
+	writeHeader(w, "chaosntpd_start_time_seconds", "gauge", "Unix time the daemon started.")
+	fmt.Fprintf(w, "chaosntpd_start_time_seconds %d\n", m.startTime.Unix())
+}
+
+// writeServerStats renders the tracker, pipeline and rate limiter state
+func writeServerStats(w io.Writer, server *NTPServer) {
+	tracker := server.tracker.Stats()
+	writeHeader(w, "chaosntpd_tracked_clients", "gauge", "Clients with manipulated time state.")
+	fmt.Fprintf(w, "chaosntpd_tracked_clients %d\n", tracker.Clients)
+	writeHeader(w, "chaosntpd_client_evictions_total", "counter", "Clients removed by cleanup, by reason.")
+	fmt.Fprintf(w, "chaosntpd_client_evictions_total{reason=\"stale\"} %d\n", tracker.StaleEvictions)
+	fmt.Fprintf(w, "chaosntpd_client_evictions_total{reason=\"limit\"} %d\n", tracker.LimitEvictions)
+
+	pipeline := server.pipeline.stats()
+	writeHeader(w, "chaosntpd_packets_received_total", "counter", "UDP packets read from the socket.")
+	fmt.Fprintf(w, "chaosntpd_packets_received_total %d\n", pipeline.Received)
+	writeHeader(w, "chaosntpd_packets_dropped_total", "counter", "UDP packets dropped before processing, by reason.")
+	fmt.Fprintf(w, "chaosntpd_packets_dropped_total{reason=\"queue_full\"} %d\n", pipeline.Dropped)
+	writeHeader(w, "chaosntpd_queue_length", "gauge", "Packets waiting for a worker.")
+	fmt.Fprintf(w, "chaosntpd_queue_length %d\n", pipeline.QueueLen)
+
+	limiter := server.policies.Load().limiter.Stats()
+	writeHeader(w, "chaosntpd_rate_limited_requests_total", "counter", "Requests over the per-source rate limit.")
+	fmt.Fprintf(w, "chaosntpd_rate_limited_requests_total %d\n", limiter.LimitedRequests)
+	writeHeader(w, "chaosntpd_rate_limited_sources", "gauge", "Sources currently over the rate limit.")
+	fmt.Fprintf(w, "chaosntpd_rate_limited_sources %d\n", limiter.LimitingSources)
+}
+
+// writeHeader writes the HELP and TYPE lines for a metric
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// writeHistogram writes a histogram's buckets, sum and count
+func writeHistogram(w io.Writer, name, help string, h *histogram) {
+	writeHeader(w, name, "histogram", help)
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatFloat(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+// formatFloat formats a float the way Prometheus clients do
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// MetricsServer serves the Prometheus endpoint
+type MetricsServer struct {
+	httpServer *http.Server
+}
+
+// NewMetricsServer creates the /metrics HTTP listener
+func NewMetricsServer(config *Config, server *NTPServer) *MetricsServer {
+	path := config.Metrics.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		server.metrics.WritePrometheus(w, server)
+	})
+
+	return &MetricsServer{
+		httpServer: &http.Server{
+			Addr:              net.JoinHostPort(config.Metrics.Host, strconv.Itoa(config.Metrics.Port)),
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// Start serves metrics until Stop is called
+func (m *MetricsServer) Start() error {
+	LogInfo("Metrics endpoint listening on %s", m.httpServer.Addr)
+
+	err := m.httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Stop shuts down the metrics listener
+func (m *MetricsServer) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return m.httpServer.Shutdown(ctx)
+}
@@ -12,27 +12,46 @@ const (
 
 	// NTP packet size
 	ntpPacketSize = 48
+
+	// Leap indicator value meaning "clock unsynchronized" (alarm condition)
+	leapAlarm = 3
 )
 
 // NTPPacket represents an NTP packet structure
 type NTPPacket struct {
-	LeapIndicator    uint8   // 2 bits
-	Version          uint8   // 3 bits
-	Mode             uint8   // 3 bits
-	Stratum          uint8   // 8 bits
-	Poll             int8    // 8 bits
-	Precision        int8    // 8 bits
-	RootDelay        uint32  // 32 bits
-	RootDispersion   uint32  // 32 bits
-	ReferenceID      [4]byte // 32 bits
-	ReferenceTime    uint64  // 64 bits
-	OriginTime       uint64  // 64 bits
-	ReceiveTime      uint64  // 64 bits
-	TransmitTime     uint64  // 64 bits
+	LeapIndicator  uint8   // 2 bits
+	Version        uint8   // 3 bits
+	Mode           uint8   // 3 bits
+	Stratum        uint8   // 8 bits
+	Poll           int8    // 8 bits
+	Precision      int8    // 8 bits
+	RootDelay      uint32  // 32 bits
+	RootDispersion uint32  // 32 bits
+	ReferenceID    [4]byte // 32 bits
+	ReferenceTime  uint64  // 64 bits
+	OriginTime     uint64  // 64 bits
+	ReceiveTime    uint64  // 64 bits
+	TransmitTime   uint64  // 64 bits
+
+	Extensions []ExtensionField // RFC 7822 extension fields
+	MAC        *MAC             // Optional authentication trailer
+}
+
+// MAC is the message authentication code trailer of an NTP packet
+// (RFC 5905 section 7.3). A MAC with key ID 0 and no digest is a crypto-NAK.
+type MAC struct {
+	KeyID  uint32
+	Digest []byte
 }
 
-// ParseNTPPacket parses an NTP packet from bytes
-func ParseNTPPacket(data []byte) (*NTPPacket, error) {
+// Length returns the size of the MAC on the wire
+func (m *MAC) Length() int {
+	return 4 + len(m.Digest)
+}
+
+// ParseNTPPacket parses an NTP packet from bytes. With strict set, a
+// trailer that is not valid RFC 7822 extension fields and MAC is an error.
+func ParseNTPPacket(data []byte, strict bool) (*NTPPacket, error) {
 	if len(data) < ntpPacketSize {
 		return nil, fmt.Errorf("invalid NTP packet: too short (%d bytes)", len(data))
 	}
@@ -60,6 +79,18 @@ func ParseNTPPacket(data []byte) (*NTPPacket, error) {
 	packet.ReceiveTime = binary.BigEndian.Uint64(data[32:40])
 	packet.TransmitTime = binary.BigEndian.Uint64(data[40:48])
 
+	// Extension fields, then an optional MAC (RFC 7822). Unless strict, a
+	// trailer that breaks the rules is ignored and the header still served.
+	extensions, mac, err := parseTrailer(data[ntpPacketSize:], packet.Version)
+	if err != nil {
+		if strict {
+			return nil, fmt.Errorf("invalid NTP packet: %w", err)
+		}
+		extensions, mac = nil, nil
+	}
+	packet.Extensions = extensions
+	packet.MAC = mac
+
 	return packet, nil
 }
 
@@ -85,6 +116,15 @@ func (p *NTPPacket) ToBytes() []byte {
 	binary.BigEndian.PutUint64(data[32:40], p.ReceiveTime)
 	binary.BigEndian.PutUint64(data[40:48], p.TransmitTime)
 
+	for _, ef := range p.Extensions {
+		data = ef.appendTo(data)
+	}
+
+	if p.MAC != nil {
+		data = binary.BigEndian.AppendUint32(data, p.MAC.KeyID)
+		data = append(data, p.MAC.Digest...)
+	}
+
 	return data
 }
 
@@ -111,7 +151,9 @@ func NTPToUnix(ntp uint64) time.Time {
 	return time.Unix(int64(secs), int64(nanos))
 }
 
-// CreateResponse creates an NTP response packet
+// CreateResponse creates an NTP response packet. The receive and transmit
+// timestamps are both set from manipulatedTime; callers should restamp
+// TransmitTime immediately before sending.
 func CreateResponse(request *NTPPacket, config *Config, manipulatedTime time.Time) *NTPPacket {
 	response := &NTPPacket{
 		LeapIndicator:  0, // No warning
@@ -136,9 +178,46 @@ func CreateResponse(request *NTPPacket, config *Config, manipulatedTime time.Tim
 	// Set timestamps
 	ntpTime := UnixToNTP(manipulatedTime)
 	response.ReferenceTime = ntpTime - (1 << 32) // Reference was 1 second ago
-	response.OriginTime = request.TransmitTime    // Echo client's transmit
-	response.ReceiveTime = ntpTime                // When we "received" it
-	response.TransmitTime = ntpTime               // When we're sending
+	response.OriginTime = request.TransmitTime   // Echo client's transmit
+	response.ReceiveTime = ntpTime               // When we "received" it
+	response.TransmitTime = ntpTime              // When we're sending
+
+	return response
+}
+
+// CreateBroadcast creates an unsolicited broadcast packet (mode 5). The
+// origin and receive timestamps are zero; callers should restamp
+// TransmitTime immediately before sending.
+func CreateBroadcast(config *Config, manipulatedTime time.Time, version uint8, poll int8) *NTPPacket {
+	packet := CreateResponse(&NTPPacket{Version: version, Poll: poll}, config, manipulatedTime)
+	packet.Mode = 5 // Broadcast
+	packet.ReceiveTime = 0
+	return packet
+}
+
+// CreateKoDResponse creates a Kiss-o'-Death packet (RFC 5905 section 7.4)
+// carrying the given kiss code in the reference ID. The receive and transmit
+// timestamps echo the client's transmit timestamp so no time is disclosed.
+// Symmetric peers (mode 1 or 2) get a symmetric passive reply, as in
+// handleRequest.
+func CreateKoDResponse(request *NTPPacket, code string) *NTPPacket {
+	response := &NTPPacket{
+		LeapIndicator: leapAlarm,
+		Version:       request.Version,
+		Mode:          4, // Server mode
+		Stratum:       0, // Kiss-o'-Death
+		Poll:          request.Poll,
+		Precision:     request.Precision,
+	}
+
+	if request.Mode == 1 || request.Mode == 2 {
+		response.Mode = 2 // Symmetric passive
+	}
+	copy(response.ReferenceID[:], code)
+
+	response.OriginTime = request.TransmitTime
+	response.ReceiveTime = request.TransmitTime
+	response.TransmitTime = request.TransmitTime
 
 	return response
 }
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestCreateKoDResponseMode(t *testing.T) {
+	tests := []struct {
+		name        string
+		requestMode uint8
+		want        uint8
+	}{
+		{"client", 3, 4},
+		{"symmetric active", 1, 2},
+		{"symmetric passive", 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := &NTPPacket{Version: 4, Mode: tt.requestMode, TransmitTime: 0x0123456789abcdef}
+			kod := CreateKoDResponse(request, "RATE")
+			if kod.Mode != tt.want {
+				t.Errorf("mode = %d, want %d", kod.Mode, tt.want)
+			}
+			if kod.Stratum != 0 || string(kod.ReferenceID[:]) != "RATE" || kod.OriginTime != request.TransmitTime {
+				t.Errorf("not a RATE kiss echoing the request: %+v", kod)
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"sync"
+	"time"
+)
+
+// NTS parameters
+const (
+	aeadAESSIVCMAC256 = 15 // IANA AEAD identifier
+	ntsKeyLength      = 32 // C2S/S2C key length for AES-SIV-CMAC-256
+	ntsNonceLength    = 16
+	ntsMaxCookies     = 8
+	ntsMinUIDLength   = 32 // RFC 8915 section 5.3
+)
+
+// Outcomes of sealing an NTS response, reported in transaction logs
+const (
+	AuthNTS            = "nts"
+	AuthNTSBadCookie   = "nts_bad_cookie"
+	AuthNTSAEADFailure = "nts_aead_failure"
+)
+
+// ntsError is a request that must be answered with an NTS NAK
+type ntsError struct {
+	uid    []byte // Unique Identifier to echo, if the request had one
+	reason string
+}
+
+func (e *ntsError) Error() string {
+	return "NTS request rejected: " + e.reason
+}
+
+// ntsRequest is an authenticated NTS request awaiting its response
+type ntsRequest struct {
+	uid     []byte
+	c2s     []byte
+	s2c     []byte
+	cookies int // number of fresh cookies to return
+}
+
+// NTS issues and opens cookies and authenticates NTS-protected NTP
+// packets. Cookies are sealed with a master key generated at startup, so
+// they do not survive a restart.
+type NTS struct {
+	enabled  bool
+	required bool
+	cookies  int
+	keyID    uint32
+	master   *aesSIV
+
+	badCookie   float64
+	aeadFailure float64
+	nak         float64
+
+	mu   sync.Mutex
+	rand *mrand.Rand
+}
+
+// NewNTS builds the NTS state from the configuration
+func NewNTS(config *Config) (*NTS, error) {
+	nc := config.NTS
+	n := &NTS{
+		enabled:     nc.Enabled,
+		required:    nc.Require,
+		cookies:     nc.Cookies,
+		badCookie:   nc.Chaos.BadCookieFraction,
+		aeadFailure: nc.Chaos.AEADFailureFraction,
+		nak:         nc.Chaos.NAKFraction,
+		rand:        mrand.New(mrand.NewSource(time.Now().UnixNano())),
+	}
+	if !n.enabled {
+		return n, nil
+	}
+	if err := validateNTS(config); err != nil {
+		return nil, err
+	}
+
+	master := make([]byte, ntsKeyLength)
+	if _, err := rand.Read(master); err != nil {
+		return nil, err
+	}
+	var id [4]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	n.keyID = binary.BigEndian.Uint32(id[:])
+
+	var err error
+	if n.master, err = newAESSIV(master); err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+// validateNTS checks the NTS settings without generating keys
+func validateNTS(config *Config) error {
+	nc := config.NTS
+	if !nc.Enabled {
+		return nil
+	}
+
+	if nc.Port <= 0 || nc.Port > 65535 {
+		return fmt.Errorf("invalid NTS-KE port: %d", nc.Port)
+	}
+	if nc.NTPPort < 0 || nc.NTPPort > 65535 {
+		return fmt.Errorf("invalid NTS ntp_port: %d", nc.NTPPort)
+	}
+	if nc.Cookies < 1 || nc.Cookies > ntsMaxCookies {
+		return fmt.Errorf("invalid NTS cookie count: %d (must be 1-%d)", nc.Cookies, ntsMaxCookies)
+	}
+	if (nc.CertFile == "") != (nc.KeyFile == "") {
+		return fmt.Errorf("NTS cert_file and key_file must be set together")
+	}
+	for _, fraction := range []float64{nc.Chaos.BadCookieFraction, nc.Chaos.AEADFailureFraction, nc.Chaos.NAKFraction} {
+		if fraction < 0 || fraction > 1 {
+			return fmt.Errorf("invalid NTS chaos fraction: %g (must be 0-1)", fraction)
+		}
+	}
+	return nil
+}
+
+// Required reports whether requests without NTS must be dropped
+func (n *NTS) Required() bool {
+	return n.enabled && n.required
+}
+
+// roll reports whether a chaos event with the given probability fires
+func (n *NTS) roll(fraction float64) bool {
+	if fraction <= 0 {
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.rand.Float64() < fraction
+}
+
+// NewCookie seals a pair of session keys into a cookie:
+// key ID (4) | nonce (16) | AES-SIV(AEAD ID (2) | reserved (2) | C2S | S2C).
+// The reserved bytes keep the cookie a multiple of 4 bytes long, so it
+// needs no padding in an extension field. corrupted reports whether chaos
+// replaced the cookie with one the server will reject.
+func (n *NTS) NewCookie(c2s, s2c []byte) (cookie []byte, corrupted bool) {
+	cookie = make([]byte, 4+ntsNonceLength, 4+ntsNonceLength+16+4+2*ntsKeyLength)
+	binary.BigEndian.PutUint32(cookie, n.keyID)
+	rand.Read(cookie[4:])
+
+	plaintext := binary.BigEndian.AppendUint32(nil, aeadAESSIVCMAC256<<16)
+	plaintext = append(plaintext, c2s...)
+	plaintext = append(plaintext, s2c...)
+	cookie = append(cookie, n.master.Seal(plaintext, cookie[:4], cookie[4:4+ntsNonceLength])...)
+
+	if n.roll(n.badCookie) {
+		rand.Read(cookie[4:])
+		return cookie, true
+	}
+	return cookie, false
+}
+
+// openCookie recovers the session keys from a cookie
+func (n *NTS) openCookie(cookie []byte) (c2s, s2c []byte, err error) {
+	if len(cookie) < 4+ntsNonceLength+16 || binary.BigEndian.Uint32(cookie) != n.keyID {
+		return nil, nil, errors.New("unknown cookie key")
+	}
+	plaintext, err := n.master.Open(cookie[4+ntsNonceLength:], cookie[:4], cookie[4:4+ntsNonceLength])
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(plaintext) != 4+2*ntsKeyLength || binary.BigEndian.Uint16(plaintext) != aeadAESSIVCMAC256 {
+		return nil, nil, errors.New("malformed cookie")
+	}
+	return plaintext[4 : 4+ntsKeyLength], plaintext[4+ntsKeyLength:], nil
+}
+
+// Open authenticates an NTS request. It returns nil, nil for requests
+// without NTS extension fields and an *ntsError for requests that should
+// get an NTS NAK. data is the request as received.
+func (n *NTS) Open(data []byte, request *NTPPacket) (*ntsRequest, error) {
+	if !n.enabled {
+		return nil, nil
+	}
+
+	var uid, cookie []byte
+	placeholders := 0
+	offset := ntpPacketSize
+	for _, ef := range request.Extensions {
+		switch ef.Type {
+		case ExtUniqueIdentifier:
+			uid = ef.Value
+		case ExtNTSCookie:
+			cookie = ef.Value
+		case ExtNTSCookiePlaceholder:
+			placeholders++
+		case ExtNTSAuthenticator:
+			if len(uid) < ntsMinUIDLength || cookie == nil {
+				return nil, &ntsError{uid: uid, reason: "malformed"}
+			}
+			if n.roll(n.nak) {
+				return nil, &ntsError{uid: uid, reason: "chaos"}
+			}
+
+			c2s, s2c, err := n.openCookie(cookie)
+			if err != nil {
+				return nil, &ntsError{uid: uid, reason: "bad_cookie"}
+			}
+			nonce, ciphertext, ok := parseNTSAuthenticator(ef.Value)
+			if !ok {
+				return nil, &ntsError{uid: uid, reason: "malformed"}
+			}
+			aead, _ := newAESSIV(c2s)
+			if _, err := aead.Open(ciphertext, data[:offset], nonce); err != nil {
+				return nil, &ntsError{uid: uid, reason: "aead_failure"}
+			}
+
+			cookies := 1 + placeholders
+			if cookies > ntsMaxCookies {
+				cookies = ntsMaxCookies
+			}
+			return &ntsRequest{uid: uid, c2s: c2s, s2c: s2c, cookies: cookies}, nil
+		}
+		offset += 4 + len(ef.Value)
+	}
+
+	if cookie != nil {
+		// Cookie without an authenticator
+		return nil, &ntsError{uid: uid, reason: "malformed"}
+	}
+	return nil, nil
+}
+
+// Seal appends the Unique Identifier and an NTS Authenticator carrying
+// fresh encrypted cookies to a response. Call Seal after the last header
+// change.
+func (n *NTS) Seal(response *NTPPacket, req *ntsRequest) string {
+	response.MAC = nil
+	response.Extensions = []ExtensionField{{Type: ExtUniqueIdentifier, Value: req.uid}}
+	associated := response.ToBytes()
+
+	outcome := AuthNTS
+	var plaintext []byte
+	for i := 0; i < req.cookies; i++ {
+		cookie, corrupted := n.NewCookie(req.c2s, req.s2c)
+		if corrupted {
+			outcome = AuthNTSBadCookie
+		}
+		plaintext = appendExtensionField(plaintext, ExtNTSCookie, cookie)
+	}
+
+	nonce := make([]byte, ntsNonceLength)
+	rand.Read(nonce)
+	aead, _ := newAESSIV(req.s2c)
+	ciphertext := aead.Seal(plaintext, associated, nonce)
+
+	// Chaos: break the authentication tag
+	if n.roll(n.aeadFailure) {
+		ciphertext[0] ^= 0xff
+		outcome = AuthNTSAEADFailure
+	}
+
+	response.Extensions = append(response.Extensions, ExtensionField{
+		Type:  ExtNTSAuthenticator,
+		Value: buildNTSAuthenticator(nonce, ciphertext),
+	})
+	return outcome
+}
+
+// NAKExtensions returns the extension fields of an NTS NAK (RFC 8915
+// section 5.7): the request's Unique Identifier, if any
+func (e *ntsError) NAKExtensions() []ExtensionField {
+	if e.uid == nil {
+		return nil
+	}
+	return []ExtensionField{{Type: ExtUniqueIdentifier, Value: e.uid}}
+}
+
+// parseNTSAuthenticator splits an NTS Authenticator body into its nonce
+// and ciphertext
+func parseNTSAuthenticator(body []byte) (nonce, ciphertext []byte, ok bool) {
+	if len(body) < 4 {
+		return nil, nil, false
+	}
+	nonceLen := int(binary.BigEndian.Uint16(body[0:2]))
+	ciphertextLen := int(binary.BigEndian.Uint16(body[2:4]))
+	nonceEnd := 4 + padTo4(nonceLen)
+	if nonceEnd > len(body) || nonceEnd+ciphertextLen > len(body) {
+		return nil, nil, false
+	}
+	return body[4 : 4+nonceLen], body[nonceEnd : nonceEnd+ciphertextLen], true
+}
+
+// buildNTSAuthenticator encodes an NTS Authenticator body
+func buildNTSAuthenticator(nonce, ciphertext []byte) []byte {
+	body := binary.BigEndian.AppendUint16(nil, uint16(len(nonce)))
+	body = binary.BigEndian.AppendUint16(body, uint16(len(ciphertext)))
+	body = append(body, nonce...)
+	body = append(body, make([]byte, padTo4(len(nonce))-len(nonce))...)
+	body = append(body, ciphertext...)
+	body = append(body, make([]byte, padTo4(len(ciphertext))-len(ciphertext))...)
+	return body
+}
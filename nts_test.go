@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// newTestNTS creates NTS state with a fresh master key
+func newTestNTS(t *testing.T) *NTS {
+	t.Helper()
+	config := &Config{}
+	config.NTS.Enabled = true
+	config.NTS.Port = 4460
+	config.NTS.Cookies = 8
+	n, err := NewNTS(config)
+	if err != nil {
+		t.Fatalf("NewNTS: %v", err)
+	}
+	return n
+}
+
+func TestNTSCookieRoundTrip(t *testing.T) {
+	n := newTestNTS(t)
+	c2s := bytes.Repeat([]byte{0x11}, ntsKeyLength)
+	s2c := bytes.Repeat([]byte{0x22}, ntsKeyLength)
+
+	cookie, corrupted := n.NewCookie(c2s, s2c)
+	if corrupted {
+		t.Fatal("cookie corrupted without chaos")
+	}
+	if len(cookie)%4 != 0 {
+		t.Errorf("cookie length %d is not a multiple of 4", len(cookie))
+	}
+	gotC2S, gotS2C, err := n.openCookie(cookie)
+	if err != nil {
+		t.Fatalf("openCookie: %v", err)
+	}
+	if !bytes.Equal(gotC2S, c2s) || !bytes.Equal(gotS2C, s2c) {
+		t.Errorf("openCookie = %x, %x; want %x, %x", gotC2S, gotS2C, c2s, s2c)
+	}
+
+	tests := []struct {
+		name   string
+		cookie func([]byte) []byte
+	}{
+		{"truncated", func(c []byte) []byte { return c[:20] }},
+		{"other key ID", func(c []byte) []byte { c[0] ^= 0xff; return c }},
+		{"flipped ciphertext", func(c []byte) []byte { c[len(c)-1] ^= 0x01; return c }},
+		{"flipped nonce", func(c []byte) []byte { c[4] ^= 0x01; return c }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bad := tt.cookie(append([]byte(nil), cookie...))
+			if _, _, err := n.openCookie(bad); err == nil {
+				t.Error("openCookie accepted a bad cookie")
+			}
+		})
+	}
+
+	// Cookies do not survive a new master key
+	if _, _, err := newTestNTS(t).openCookie(cookie); err == nil {
+		t.Error("openCookie accepted a cookie from another master key")
+	}
+}
+
+func TestNTSOpenRejectsShortUID(t *testing.T) {
+	n := newTestNTS(t)
+	cookie, _ := n.NewCookie(make([]byte, ntsKeyLength), make([]byte, ntsKeyLength))
+	for _, size := range []int{4, ntsMinUIDLength - 4} {
+		request := &NTPPacket{Version: 4, Mode: 3, Extensions: []ExtensionField{
+			{Type: ExtUniqueIdentifier, Value: make([]byte, size)},
+			{Type: ExtNTSCookie, Value: cookie},
+			{Type: ExtNTSAuthenticator, Value: buildNTSAuthenticator(make([]byte, ntsNonceLength), make([]byte, 16))},
+		}}
+		_, err := n.Open(request.ToBytes(), request)
+		if nak, ok := err.(*ntsError); !ok || nak.reason != "malformed" {
+			t.Errorf("%d byte UID: Open error = %v, want a malformed NAK", size, err)
+		}
+	}
+}
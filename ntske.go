@@ -0,0 +1,364 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// NTS-KE record types (RFC 8915 section 4)
+const (
+	keRecordEnd          = 0
+	keRecordNextProtocol = 1
+	keRecordError        = 2
+	keRecordWarning      = 3
+	keRecordAEAD         = 4
+	keRecordNewCookie    = 5
+	keRecordServer       = 6
+	keRecordPort         = 7
+
+	keCriticalBit = 0x8000
+)
+
+// NTS-KE error codes
+const (
+	keErrorUnrecognizedCritical = 0
+	keErrorBadRequest           = 1
+	keErrorInternal             = 2
+)
+
+// NTS-KE protocol constants
+const (
+	ntsALPN          = "ntske/1"
+	ntsExporterLabel = "EXPORTER-network-time-security"
+	ntsProtocolNTPv4 = 0
+	ntsKETimeout     = 10 * time.Second
+	ntsKEMaxRequest  = 16 * 1024
+)
+
+// keRecord is one NTS-KE message record
+type keRecord struct {
+	critical bool
+	typ      uint16
+	body     []byte
+}
+
+// NTSKEServer is the NTS Key Establishment TLS listener. It negotiates
+// NTPv4 with AES-SIV-CMAC-256 and hands out cookies for the NTP port.
+type NTSKEServer struct {
+	config   *Config
+	server   *NTPServer
+	nts      *NTS
+	listener net.Listener
+}
+
+// NewNTSKEServer creates the NTS-KE server
+func NewNTSKEServer(config *Config, server *NTPServer) *NTSKEServer {
+	return &NTSKEServer{config: config, server: server, nts: server.nts}
+}
+
+// Start serves NTS-KE until Stop is called
+func (k *NTSKEServer) Start() error {
+	cert, err := loadNTSCertificate(k.config)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+		NextProtos:   []string{ntsALPN},
+	}
+
+	addr := net.JoinHostPort(k.config.NTS.Host, strconv.Itoa(k.config.NTS.Port))
+	listener, err := tls.Listen("tcp", addr, tlsConfig)
+	if err != nil {
+		return fmt.Errorf("failed to listen for NTS-KE: %w", err)
+	}
+	k.listener = listener
+
+	fingerprint := sha256.Sum256(cert.Certificate[0])
+	LogInfo("NTS-KE listening on %s (certificate SHA-256 %s)", addr, hex.EncodeToString(fingerprint[:]))
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			LogWarning("NTS-KE accept failed: %v", err)
+			continue
+		}
+		go k.handle(conn.(*tls.Conn))
+	}
+}
+
+// Stop closes the NTS-KE listener
+func (k *NTSKEServer) Stop() error {
+	if k.listener == nil {
+		return nil
+	}
+	return k.listener.Close()
+}
+
+// handle runs one key establishment exchange
+func (k *NTSKEServer) handle(conn *tls.Conn) {
+	defer conn.Close()
+
+	// Access lists and rate limits apply before the TLS handshake
+	tcpAddr := conn.RemoteAddr().(*net.TCPAddr)
+	addr := &net.UDPAddr{IP: tcpAddr.IP, Port: tcpAddr.Port, Zone: tcpAddr.Zone}
+	if !k.server.admit(k.server.policies.Load(), addr, "nts-ke") {
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(ntsKETimeout))
+
+	if err := conn.Handshake(); err != nil {
+		LogDebug("NTS-KE handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != ntsALPN {
+		LogDebug("NTS-KE client %s did not negotiate %s", conn.RemoteAddr(), ntsALPN)
+		return
+	}
+
+	records, err := readKERecords(io.LimitReader(conn, ntsKEMaxRequest))
+	if err != nil {
+		LogDebug("NTS-KE request from %s rejected: %v", conn.RemoteAddr(), err)
+		conn.Write(keErrorResponse(keErrorBadRequest))
+		return
+	}
+
+	response, cookies := k.negotiate(records, &state)
+	if _, err := conn.Write(response); err != nil {
+		LogDebug("NTS-KE response to %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	LogDebug("NTS-KE: issued %d cookies to %s", cookies, conn.RemoteAddr())
+}
+
+// negotiate builds the response to a client's records and reports how
+// many cookies it carries
+func (k *NTSKEServer) negotiate(records []keRecord, state *tls.ConnectionState) ([]byte, int) {
+	var protocols, aeads []uint16
+	seenProtocols := false
+	for _, record := range records {
+		switch record.typ {
+		case keRecordNextProtocol:
+			if seenProtocols {
+				return keErrorResponse(keErrorBadRequest), 0
+			}
+			seenProtocols = true
+			protocols = keUint16s(record.body)
+		case keRecordAEAD:
+			aeads = keUint16s(record.body)
+		case keRecordEnd, keRecordServer, keRecordPort:
+		case keRecordError, keRecordWarning:
+			return keErrorResponse(keErrorBadRequest), 0
+		default:
+			if record.critical {
+				return keErrorResponse(keErrorUnrecognizedCritical), 0
+			}
+		}
+	}
+	if !seenProtocols {
+		return keErrorResponse(keErrorBadRequest), 0
+	}
+
+	// No protocol or algorithm in common: answer with empty records
+	var out []byte
+	if !containsUint16(protocols, ntsProtocolNTPv4) {
+		out = appendKERecord(out, true, keRecordNextProtocol, nil)
+		return appendKERecord(out, true, keRecordEnd, nil), 0
+	}
+	out = appendKERecord(out, true, keRecordNextProtocol, binary.BigEndian.AppendUint16(nil, ntsProtocolNTPv4))
+	if !containsUint16(aeads, aeadAESSIVCMAC256) {
+		out = appendKERecord(out, true, keRecordAEAD, nil)
+		return appendKERecord(out, true, keRecordEnd, nil), 0
+	}
+	out = appendKERecord(out, true, keRecordAEAD, binary.BigEndian.AppendUint16(nil, aeadAESSIVCMAC256))
+
+	c2s, err := state.ExportKeyingMaterial(ntsExporterLabel, ntsExporterContext(0), ntsKeyLength)
+	if err != nil {
+		return keErrorResponse(keErrorInternal), 0
+	}
+	s2c, err := state.ExportKeyingMaterial(ntsExporterLabel, ntsExporterContext(1), ntsKeyLength)
+	if err != nil {
+		return keErrorResponse(keErrorInternal), 0
+	}
+
+	if server := k.config.NTS.NTPServer; server != "" {
+		out = appendKERecord(out, false, keRecordServer, []byte(server))
+	}
+	if port := k.config.NTS.NTPPort; port != 0 {
+		out = appendKERecord(out, false, keRecordPort, binary.BigEndian.AppendUint16(nil, uint16(port)))
+	}
+	for i := 0; i < k.nts.cookies; i++ {
+		cookie, _ := k.nts.NewCookie(c2s, s2c)
+		out = appendKERecord(out, false, keRecordNewCookie, cookie)
+	}
+
+	return appendKERecord(out, true, keRecordEnd, nil), k.nts.cookies
+}
+
+// ntsExporterContext is the TLS exporter context for the C2S (0) or
+// S2C (1) key: protocol ID, AEAD ID, direction
+func ntsExporterContext(direction byte) []byte {
+	context := binary.BigEndian.AppendUint16(nil, ntsProtocolNTPv4)
+	context = binary.BigEndian.AppendUint16(context, aeadAESSIVCMAC256)
+	return append(context, direction)
+}
+
+// readKERecords reads records up to and including End of Message
+func readKERecords(r io.Reader) ([]keRecord, error) {
+	var records []keRecord
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, fmt.Errorf("reading record header: %w", err)
+		}
+		typ := binary.BigEndian.Uint16(header[0:2])
+		record := keRecord{
+			critical: typ&keCriticalBit != 0,
+			typ:      typ &^ keCriticalBit,
+			body:     make([]byte, binary.BigEndian.Uint16(header[2:4])),
+		}
+		if _, err := io.ReadFull(r, record.body); err != nil {
+			return nil, fmt.Errorf("reading record body: %w", err)
+		}
+		records = append(records, record)
+		if record.typ == keRecordEnd {
+			return records, nil
+		}
+	}
+}
+
+// appendKERecord encodes one NTS-KE record
+func appendKERecord(b []byte, critical bool, typ uint16, body []byte) []byte {
+	if critical {
+		typ |= keCriticalBit
+	}
+	b = binary.BigEndian.AppendUint16(b, typ)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(body)))
+	return append(b, body...)
+}
+
+// keErrorResponse encodes an Error record followed by End of Message
+func keErrorResponse(code uint16) []byte {
+	out := appendKERecord(nil, true, keRecordError, binary.BigEndian.AppendUint16(nil, code))
+	return appendKERecord(out, true, keRecordEnd, nil)
+}
+
+// keUint16s decodes a record body as a list of 16-bit identifiers
+func keUint16s(body []byte) []uint16 {
+	values := make([]uint16, 0, len(body)/2)
+	for i := 0; i+1 < len(body); i += 2 {
+		values = append(values, binary.BigEndian.Uint16(body[i:]))
+	}
+	return values
+}
+
+// containsUint16 reports whether values contains v
+func containsUint16(values []uint16, v uint16) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// loadNTSCertificate loads the configured certificate. If the configured
+// files do not exist yet, a self-signed certificate is generated and
+// written there; with no files configured it is kept in memory only.
+func loadNTSCertificate(config *Config) (tls.Certificate, error) {
+	nc := config.NTS
+	if nc.CertFile != "" {
+		_, certErr := os.Stat(nc.CertFile)
+		_, keyErr := os.Stat(nc.KeyFile)
+		if certErr == nil && keyErr == nil {
+			return tls.LoadX509KeyPair(nc.CertFile, nc.KeyFile)
+		}
+		if !errors.Is(certErr, os.ErrNotExist) || !errors.Is(keyErr, os.ErrNotExist) {
+			return tls.Certificate{}, fmt.Errorf("NTS certificate: %s and %s must both exist or both be missing", nc.CertFile, nc.KeyFile)
+		}
+	}
+
+	certPEM, keyPEM, err := generateCertificate(nc.ServerName)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("generating NTS certificate: %w", err)
+	}
+
+	if nc.CertFile != "" {
+		if err := os.WriteFile(nc.KeyFile, keyPEM, 0o600); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := os.WriteFile(nc.CertFile, certPEM, 0o644); err != nil {
+			return tls.Certificate{}, err
+		}
+		LogInfo("Generated self-signed NTS certificate for %s in %s", nc.ServerName, nc.CertFile)
+	} else {
+		LogInfo("Using an in-memory self-signed NTS certificate for %s", nc.ServerName)
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// generateCertificate creates a self-signed ECDSA certificate for name
+func generateCertificate(name string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// RFC 8915 section 4 record round trips
+func TestKERecordRoundTrip(t *testing.T) {
+	records := []keRecord{
+		{critical: true, typ: keRecordNextProtocol, body: []byte{0, 0}},
+		{critical: false, typ: keRecordAEAD, body: []byte{0, 15, 0, 17}},
+		{critical: false, typ: keRecordNewCookie, body: bytes.Repeat([]byte{0xab}, 100)},
+		{critical: false, typ: keRecordServer, body: []byte("ntp.example.com")},
+		{critical: false, typ: 0x4000, body: nil}, // Unknown, non-critical
+		{critical: true, typ: keRecordEnd, body: nil},
+	}
+
+	var wire []byte
+	for _, r := range records {
+		wire = appendKERecord(wire, r.critical, r.typ, r.body)
+	}
+
+	got, err := readKERecords(bytes.NewReader(wire))
+	if err != nil {
+		t.Fatalf("readKERecords: %v", err)
+	}
+	if len(got) != len(records) {
+		t.Fatalf("got %d records, want %d", len(got), len(records))
+	}
+	for i, r := range records {
+		if got[i].critical != r.critical || got[i].typ != r.typ || !bytes.Equal(got[i].body, r.body) {
+			t.Errorf("record %d = %+v, want %+v", i, got[i], r)
+		}
+	}
+}
+
+func TestReadKERecordsErrors(t *testing.T) {
+	nextProtocol := appendKERecord(nil, true, keRecordNextProtocol, []byte{0, 0})
+
+	tests := []struct {
+		name string
+		wire []byte
+	}{
+		{"empty", nil},
+		{"truncated header", []byte{0x80, 0x01}},
+		{"truncated body", []byte{0x80, 0x01, 0x00, 0x04, 0x00}},
+		{"no end of message", nextProtocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if records, err := readKERecords(bytes.NewReader(tt.wire)); err == nil {
+				t.Errorf("readKERecords = %+v, want error", records)
+			}
+		})
+	}
+
+	// Records after End of Message are left unread
+	wire := appendKERecord(nil, true, keRecordEnd, nil)
+	wire = append(wire, nextProtocol...)
+	records, err := readKERecords(bytes.NewReader(wire))
+	if err != nil || len(records) != 1 {
+		t.Errorf("readKERecords = %d records, %v; want 1", len(records), err)
+	}
+}
+
+func TestNegotiateRejects(t *testing.T) {
+	end := keRecord{critical: true, typ: keRecordEnd}
+	protocol := func(ids ...uint16) keRecord {
+		body := []byte{}
+		for _, id := range ids {
+			body = binary.BigEndian.AppendUint16(body, id)
+		}
+		return keRecord{critical: true, typ: keRecordNextProtocol, body: body}
+	}
+	aead := func(ids ...uint16) keRecord {
+		r := protocol(ids...)
+		r.typ = keRecordAEAD
+		return r
+	}
+	noProtocol := appendKERecord(nil, true, keRecordNextProtocol, nil)
+	noProtocol = appendKERecord(noProtocol, true, keRecordEnd, nil)
+	noAEAD := appendKERecord(nil, true, keRecordNextProtocol, []byte{0, 0})
+	noAEAD = appendKERecord(noAEAD, true, keRecordAEAD, nil)
+	noAEAD = appendKERecord(noAEAD, true, keRecordEnd, nil)
+
+	tests := []struct {
+		name    string
+		records []keRecord
+		want    []byte
+	}{
+		{"missing next protocol", []keRecord{aead(aeadAESSIVCMAC256), end}, keErrorResponse(keErrorBadRequest)},
+		{"repeated next protocol", []keRecord{protocol(0), protocol(0), end}, keErrorResponse(keErrorBadRequest)},
+		{"client error record", []keRecord{protocol(0), {critical: true, typ: keRecordError, body: []byte{0, 1}}, end},
+			keErrorResponse(keErrorBadRequest)},
+		{"unknown critical record", []keRecord{protocol(0), {critical: true, typ: 0x4000}, end},
+			keErrorResponse(keErrorUnrecognizedCritical)},
+		{"no common protocol", []keRecord{protocol(1), aead(aeadAESSIVCMAC256), end}, noProtocol},
+		{"no common AEAD", []keRecord{protocol(0), aead(17), end}, noAEAD},
+	}
+	k := &NTSKEServer{config: &Config{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, cookies := k.negotiate(tt.records, nil)
+			if !bytes.Equal(got, tt.want) || cookies != 0 {
+				t.Errorf("negotiate = %x (%d cookies), want %x", got, cookies, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PeerSnapshot is the JSON view of a symmetric peer association
+type PeerSnapshot struct {
+	Address           string    `json:"address"`
+	Key               string    `json:"key"`    // Tracker key of the peer's timeline
+	Active            bool      `json:"active"` // Configured; we poll it in mode 1
+	Mode              int       `json:"mode,omitempty"`
+	Version           int       `json:"version,omitempty"`
+	Stratum           int       `json:"stratum"`
+	ReferenceID       string    `json:"reference_id,omitempty"`
+	Poll              int       `json:"poll"`
+	FirstSeen         time.Time `json:"first_seen"`
+	LastSeen          time.Time `json:"last_seen"`
+	Received          uint64    `json:"received"`
+	Sent              uint64    `json:"sent"`
+	Samples           uint64    `json:"samples"`             // Complete timestamp exchanges
+	OffsetSeconds     float64   `json:"offset_seconds"`      // Peer clock minus the time we serve it
+	RealOffsetSeconds float64   `json:"real_offset_seconds"` // Peer clock minus real time
+	DelaySeconds      float64   `json:"delay_seconds"`
+}
+
+// peerState is one association plus the timestamps of the symmetric
+// exchange (RFC 5905 section 8)
+type peerState struct {
+	PeerSnapshot
+
+	org    uint64        // Peer's last transmit time, echoed as our origin
+	rec    uint64        // When we (manipulated) received it
+	xmt    uint64        // Our last (manipulated) transmit time
+	xmtRaw time.Time     // Real time of our last transmit
+	skew   time.Duration // Manipulated minus real time at our last poll
+}
+
+// PeerTable tracks symmetric peer associations. Incoming symmetric active
+// (mode 1) packets are answered in symmetric passive mode (mode 2);
+// configured peers are polled in symmetric active mode.
+type PeerTable struct {
+	enabled  bool
+	poll     time.Duration
+	pollLog2 int8
+	keyID    uint32
+	maxPeers int
+	addrs    []string // Configured peers, resolved on every poll
+
+	mu     sync.Mutex
+	peers  map[string]*peerState // By address:port
+	active map[string]bool       // Resolved configured peers
+}
+
+// NewPeerTable validates the symmetric mode settings
+func NewPeerTable(config *Config) (*PeerTable, error) {
+	sc := config.Symmetric
+	table := &PeerTable{
+		enabled:  sc.Enabled,
+		poll:     time.Duration(sc.PollSeconds) * time.Second,
+		pollLog2: int8(bits.Len(uint(sc.PollSeconds)) - 1),
+		keyID:    sc.KeyID,
+		maxPeers: sc.MaxPeers,
+		peers:    make(map[string]*peerState),
+		active:   make(map[string]bool),
+	}
+	if !sc.Enabled {
+		return table, nil
+	}
+
+	if sc.PollSeconds < 1 || sc.PollSeconds > 131072 {
+		return nil, fmt.Errorf("invalid symmetric poll interval: %d (must be 1-131072 seconds)", sc.PollSeconds)
+	}
+	if sc.MaxPeers < 1 || sc.MaxPeers < len(sc.Peers) {
+		return nil, fmt.Errorf("invalid max_peers: %d (must be positive and at least the %d configured peers)",
+			sc.MaxPeers, len(sc.Peers))
+	}
+	for _, peer := range sc.Peers {
+		host, port, err := net.SplitHostPort(peer)
+		if err != nil {
+			host, port = peer, "123"
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 || host == "" {
+			return nil, fmt.Errorf("invalid symmetric peer: %q (must be host or host:port)", peer)
+		}
+		table.addrs = append(table.addrs, net.JoinHostPort(host, port))
+	}
+
+	return table, nil
+}
+
+// Enabled reports whether symmetric modes are answered
+func (t *PeerTable) Enabled() bool {
+	return t.enabled
+}
+
+// IsActive reports whether addr is a configured (polled) peer
+func (t *PeerTable) IsActive(addr *net.UDPAddr) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active[addr.String()]
+}
+
+// Skew returns how far the timeline served to a configured peer at our
+// last poll was from the real time. Their packets are stamped with it so
+// only the poll advances their timeline.
+func (t *PeerTable) Skew(addr *net.UDPAddr) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if st, ok := t.peers[addr.String()]; ok {
+		return st.skew
+	}
+	return 0
+}
+
+// Observe records a symmetric packet received at rec (manipulated) and
+// rxTime (real). It reports false when a new peer is refused because the
+// table is full of configured peers.
+func (t *PeerTable) Observe(addr *net.UDPAddr, key string, packet *NTPPacket, rec uint64, rxTime time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st := t.lookup(addr, key, rxTime)
+	if st == nil {
+		LogDebug("Refusing symmetric peer %s: all %d associations are configured peers", addr.String(), t.maxPeers)
+		return false
+	}
+	st.Mode = int(packet.Mode)
+	st.Version = int(packet.Version)
+	st.Stratum = int(packet.Stratum)
+	st.ReferenceID = formatReferenceID(packet)
+	st.Poll = int(packet.Poll)
+	st.LastSeen = rxTime
+	st.Received++
+
+	// A packet echoing our last transmit completes an exchange:
+	// T1 = our transmit, T2 = their receive, T3 = their transmit, T4 = rec
+	if st.xmt != 0 && packet.OriginTime == st.xmt {
+		st.Samples++
+		st.OffsetSeconds = (ntpSeconds(packet.ReceiveTime, st.xmt) + ntpSeconds(packet.TransmitTime, rec)) / 2
+		// Jitter makes our manipulated timestamps useless for the delay
+		st.DelaySeconds = rxTime.Sub(st.xmtRaw).Seconds() - ntpSeconds(packet.TransmitTime, packet.ReceiveTime)
+		st.RealOffsetSeconds = (ntpSeconds(packet.ReceiveTime, UnixToNTP(st.xmtRaw)) +
+			ntpSeconds(packet.TransmitTime, UnixToNTP(rxTime))) / 2
+	}
+	st.org = packet.TransmitTime
+	st.rec = rec
+
+	return true
+}
+
+// Sent records a packet we transmitted to a peer
+func (t *PeerTable) Sent(addr *net.UDPAddr, xmt uint64, txTime time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if st, ok := t.peers[addr.String()]; ok {
+		st.xmt = xmt
+		st.xmtRaw = txTime
+		st.Sent++
+	}
+}
+
+// lookup returns the association for addr, creating it (and evicting the
+// least recently seen unconfigured association when full) if needed. It
+// returns nil when the table is full of configured peers.
+func (t *PeerTable) lookup(addr *net.UDPAddr, key string, now time.Time) *peerState {
+	id := addr.String()
+	if st, ok := t.peers[id]; ok {
+		return st
+	}
+
+	if len(t.peers) >= t.maxPeers {
+		var oldest string
+		for candidate, st := range t.peers {
+			if st.Active {
+				continue
+			}
+			if oldest == "" || st.LastSeen.Before(t.peers[oldest].LastSeen) {
+				oldest = candidate
+			}
+		}
+		if oldest == "" {
+			return nil
+		}
+		delete(t.peers, oldest)
+	}
+
+	st := &peerState{PeerSnapshot: PeerSnapshot{
+		Address:   id,
+		Key:       key,
+		Active:    t.active[id],
+		FirstSeen: now,
+	}}
+	t.peers[id] = st
+	return st
+}
+
+// Peers returns all associations, sorted by address
+func (t *PeerTable) Peers() []PeerSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	peers := make([]PeerSnapshot, 0, len(t.peers))
+	for _, st := range t.peers {
+		peers = append(peers, st.PeerSnapshot)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Address < peers[j].Address })
+	return peers
+}
+
+// peerLoop polls the configured peers in symmetric active mode until the
+// server socket is closed
+func (s *NTPServer) peerLoop() {
+	ticker := time.NewTicker(s.peers.poll)
+	defer ticker.Stop()
+
+	for {
+		for _, peer := range s.peers.addrs {
+			addr, err := net.ResolveUDPAddr("udp", peer)
+			if err != nil {
+				LogWarning("Cannot resolve symmetric peer %s: %v", peer, err)
+				continue
+			}
+			if err := s.pollPeer(addr); errors.Is(err, net.ErrClosed) {
+				return
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// pollPeer sends one symmetric active packet to a configured peer
+func (s *NTPServer) pollPeer(addr *net.UDPAddr) error {
+	p := s.policies.Load()
+	t := s.peers
+
+	// A peer that contacted us first keeps the key it was seen with
+	t.mu.Lock()
+	t.active[addr.String()] = true
+	st := t.lookup(addr, p.keyer.KeyForHost(addr.IP), time.Now())
+	if st == nil {
+		delete(t.active, addr.String())
+		t.mu.Unlock()
+		LogWarning("Cannot poll symmetric peer %s: max_peers (%d) reached", addr.String(), t.maxPeers)
+		return nil
+	}
+	st.Active = true
+	key, org, rec := st.Key, st.org, st.rec
+	t.mu.Unlock()
+
+	now := time.Now()
+	manipulatedTime, _, _ := s.tracker.GetManipulatedTime(key, addr.IP, now)
+	servedTime, leapIndicator := p.leap.Apply(manipulatedTime)
+	t.mu.Lock()
+	st.skew = manipulatedTime.Sub(now)
+	t.mu.Unlock()
+
+	// Origin and receive timestamps describe the peer's last packet
+	packet := CreateResponse(&NTPPacket{Version: 4, TransmitTime: org}, p.config, servedTime)
+	packet.Mode = 1 // Symmetric active
+	packet.Poll = t.pollLog2
+	packet.LeapIndicator = leapIndicator
+	packet.ReceiveTime = rec
+
+	txTime := time.Now()
+	txServed, _ := p.leap.Apply(txTime.Add(manipulatedTime.Sub(now)))
+	packet.TransmitTime = UnixToNTP(txServed)
+
+	p.extensions.Inject(packet)
+	if t.keyID != 0 {
+		p.auth.Sign(packet, authResult{status: authValid, keyID: t.keyID})
+	}
+
+	if _, err := s.conn.WriteToUDP(packet.ToBytes(), addr); err != nil {
+		if !errors.Is(err, net.ErrClosed) {
+			LogError("Error polling symmetric peer %s: %v", addr.String(), err)
+			s.metrics.RecordResponse(OutcomeDropped, "send_error")
+		}
+		return err
+	}
+	t.Sent(addr, packet.TransmitTime, txTime)
+	s.metrics.RecordResponse(OutcomePeerPoll, "ok")
+	LogDebug("Polled symmetric peer %s", addr.String())
+	return nil
+}
+
+// ntpSeconds returns a - b in seconds for NTP timestamps
+func ntpSeconds(a, b uint64) float64 {
+	return float64(int64(a-b)) / (1 << 32)
+}
+
+// formatReferenceID renders a reference ID as ASCII for stratum 0/1 and as
+// an IPv4 address otherwise (RFC 5905 section 7.3)
+func formatReferenceID(packet *NTPPacket) string {
+	if packet.Stratum <= 1 {
+		return string(trimNUL(packet.ReferenceID[:]))
+	}
+	return net.IP(packet.ReferenceID[:]).String()
+}
+
+// trimNUL drops trailing NUL bytes
+func trimNUL(b []byte) []byte {
+	for len(b) > 0 && b[len(b)-1] == 0 {
+		b = b[:len(b)-1]
+	}
+	return b
+}
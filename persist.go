@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateFileVersion is bumped when the snapshot format changes incompatibly
+const stateFileVersion = 1
+
+// stateSnapshot is the on-disk form of the tracker's client states
+type stateSnapshot struct {
+	Version           int                     `json:"version"`
+	SavedAt           time.Time               `json:"saved_at"`
+	ScenarioStartTime time.Time               `json:"scenario_start_time"`
+	Clients           map[string]*ClientState `json:"clients"`
+}
+
+// SaveState writes all client states to path atomically
+func (t *ClientTimeTracker) SaveState(path string) (int, error) {
+	t.mu.RLock()
+	snapshot := stateSnapshot{
+		Version:           stateFileVersion,
+		SavedAt:           time.Now(),
+		ScenarioStartTime: t.startTime,
+		Clients:           make(map[string]*ClientState, len(t.clientStates)),
+	}
+	for key, state := range t.clientStates {
+		copied := *state
+		snapshot.Clients[key] = &copied
+	}
+	t.mu.RUnlock()
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return 0, fmt.Errorf("error encoding client state: %w", err)
+	}
+
+	// Write to a temporary file and rename so a crash never leaves a
+	// truncated snapshot behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return 0, fmt.Errorf("error writing state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, fmt.Errorf("error writing state file: %w", err)
+	}
+
+	return len(snapshot.Clients), nil
+}
+
+// LoadState restores client states saved by SaveState. Each client's clock
+// is rebased by the downtime, so its manipulated time keeps running (at its
+// own frequency) as if the daemon had never stopped. A missing file is not
+// an error.
+func (t *ClientTimeTracker) LoadState(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error reading state file: %w", err)
+	}
+
+	var snapshot stateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("error parsing state file: %w", err)
+	}
+	if snapshot.Version != stateFileVersion {
+		return 0, fmt.Errorf("unsupported state file version %d (expected %d)", snapshot.Version, stateFileVersion)
+	}
+
+	downtime := time.Since(snapshot.SavedAt)
+	if downtime < 0 {
+		downtime = 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, state := range snapshot.Clients {
+		if !state.Frozen {
+			state.LastManipulatedTime = state.LastManipulatedTime.Add(scaleByFrequency(downtime, state.FrequencyPPM))
+		}
+		state.LastActualTime = state.LastActualTime.Add(downtime)
+		state.FirstSeen = state.FirstSeen.Add(downtime)
+		t.clientStates[key] = state
+	}
+
+	// Resume a global scenario timeline where it left off
+	if !snapshot.ScenarioStartTime.IsZero() {
+		t.startTime = snapshot.ScenarioStartTime.Add(downtime)
+	}
+
+	LogInfo("Restored %d clients from %s (down for %s)", len(snapshot.Clients), path, downtime.Round(time.Second))
+	return len(snapshot.Clients), nil
+}
+
+// persistLoop periodically snapshots client state
+func (t *ClientTimeTracker) persistLoop(path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := t.SaveState(path); err != nil {
+			LogError("Failed to save client state: %v", err)
+		}
+	}
+}
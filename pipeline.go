@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxPacketSize bounds a received datagram, leaving room for extension fields
+const maxPacketSize = 2048
+
+// packetBufferPool recycles receive buffers so each in-flight packet owns
+// its bytes until a worker has finished with it
+var packetBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, maxPacketSize)
+		return &buf
+	},
+}
+
+// receivedPacket is a datagram waiting in the worker queue
+type receivedPacket struct {
+	buf    *[]byte
+	n      int
+	addr   *net.UDPAddr
+	rxTime time.Time
+}
+
+// PipelineStats is a snapshot of receive pipeline counters
+type PipelineStats struct {
+	Received   uint64 `json:"received"`
+	Dropped    uint64 `json:"dropped"` // Queue full
+	Processed  uint64 `json:"processed"`
+	ReadErrors uint64 `json:"read_errors"`
+	QueueLen   int    `json:"queue_len"`
+	QueueCap   int    `json:"queue_cap"`
+	Workers    int    `json:"workers"`
+}
+
+// packetHandler processes one datagram received at rxTime
+type packetHandler func(data []byte, addr *net.UDPAddr, rxTime time.Time)
+
+// pipeline is a bounded worker pool fed by the UDP read loop
+type pipeline struct {
+	queue   chan receivedPacket
+	workers int
+	wg      sync.WaitGroup
+
+	received   atomic.Uint64
+	dropped    atomic.Uint64
+	processed  atomic.Uint64
+	readErrors atomic.Uint64
+}
+
+// newPipeline creates a pipeline from the server configuration
+func newPipeline(config *Config) *pipeline {
+	workers := config.Server.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	return &pipeline{
+		queue:   make(chan receivedPacket, config.Server.QueueDepth),
+		workers: workers,
+	}
+}
+
+// run reads packets from conn and dispatches them to the workers until the
+// connection is closed, then waits for queued packets to drain
+func (p *pipeline) run(conn *net.UDPConn, handle packetHandler) error {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.worker(handle)
+	}
+
+	defer func() {
+		close(p.queue)
+		p.wg.Wait()
+	}()
+
+	oob := make([]byte, rxOOBSize)
+	for {
+		buf := packetBufferPool.Get().(*[]byte)
+		n, addr, rxTime, err := readPacket(conn, *buf, oob)
+		if err != nil {
+			packetBufferPool.Put(buf)
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			p.readErrors.Add(1)
+			LogError("Error reading UDP packet: %v", err)
+			continue
+		}
+		p.received.Add(1)
+
+		select {
+		case p.queue <- receivedPacket{buf: buf, n: n, addr: addr, rxTime: rxTime}:
+		default:
+			// Queue full: shed load rather than block the socket
+			p.dropped.Add(1)
+			packetBufferPool.Put(buf)
+		}
+	}
+}
+
+// worker processes queued packets and returns their buffers to the pool
+func (p *pipeline) worker(handle packetHandler) {
+	defer p.wg.Done()
+
+	for pkt := range p.queue {
+		handle((*pkt.buf)[:pkt.n], pkt.addr, pkt.rxTime)
+		packetBufferPool.Put(pkt.buf)
+		p.processed.Add(1)
+	}
+}
+
+// stats returns a snapshot of the pipeline counters
+func (p *pipeline) stats() PipelineStats {
+	return PipelineStats{
+		Received:   p.received.Load(),
+		Dropped:    p.dropped.Load(),
+		Processed:  p.processed.Load(),
+		ReadErrors: p.readErrors.Load(),
+		QueueLen:   len(p.queue),
+		QueueCap:   cap(p.queue),
+		Workers:    p.workers,
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// ManipulationProfile overrides the time manipulation for matching clients.
+// Unset fields inherit the time_manipulation settings.
+type ManipulationProfile struct {
+	Name                 string   `yaml:"name"`
+	Match                []string `yaml:"match"`                  // CIDRs, IPs or client keys
+	Honest               bool     `yaml:"honest"`                 // Serve the real time
+	OffsetSeconds        *float64 `yaml:"offset_seconds"`         // Fixed initial offset
+	InitialOffsetMinutes *int     `yaml:"initial_offset_minutes"` // Random initial offset of ±N minutes
+	JitterSeconds        *int     `yaml:"jitter_seconds"`
+	DriftPPM             *float64 `yaml:"drift_ppm"` // Fixed oscillator frequency error
+	WanderPPM            *float64 `yaml:"wander_ppm"`
+}
+
+// manipulation is the resolved set of settings for one group of clients
+type manipulation struct {
+	name         string
+	honest       bool     // bypasses scenarios as well
+	fixedOffset  *float64 // seconds; nil = sample within ±offsetBound
+	offsetBound  float64  // seconds
+	jitterBound  float64  // seconds
+	driftEnabled bool
+	minPPM       float64
+	maxPPM       float64
+	wanderPPM    float64
+}
+
+// profileRule selects clients for a profile
+type profileRule struct {
+	networks     []*net.IPNet
+	keys         map[string]bool
+	manipulation *manipulation
+}
+
+// ProfileSet assigns clients to manipulation profiles. The first matching
+// profile wins; clients matching none use the default settings.
+type ProfileSet struct {
+	defaults *manipulation
+	rules    []profileRule
+	byName   map[string]*manipulation
+}
+
+// NewProfileSet builds the profile set from the configuration
+func NewProfileSet(config *Config) (*ProfileSet, error) {
+	defaults := defaultManipulation(config)
+	set := &ProfileSet{
+		defaults: defaults,
+		byName:   make(map[string]*manipulation),
+	}
+
+	for i, profile := range config.Profiles {
+		if profile.Name == "" {
+			return nil, fmt.Errorf("profile %d: name is required", i+1)
+		}
+		if _, exists := set.byName[profile.Name]; exists {
+			return nil, fmt.Errorf("duplicate profile name %q", profile.Name)
+		}
+		if len(profile.Match) == 0 {
+			return nil, fmt.Errorf("profile %q: match is required", profile.Name)
+		}
+
+		m, err := resolveProfile(profile, *defaults)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %w", profile.Name, err)
+		}
+
+		rule := profileRule{keys: make(map[string]bool), manipulation: m}
+		for _, match := range profile.Match {
+			if network, err := ParseNetwork(match); err == nil {
+				rule.networks = append(rule.networks, network)
+			} else {
+				rule.keys[match] = true
+			}
+		}
+
+		set.rules = append(set.rules, rule)
+		set.byName[profile.Name] = m
+	}
+
+	return set, nil
+}
+
+// defaultManipulation returns the time_manipulation settings
+func defaultManipulation(config *Config) *manipulation {
+	tm := config.TimeManipulation
+	return &manipulation{
+		offsetBound:  float64(tm.InitialOffsetMinutes * 60),
+		jitterBound:  float64(tm.JitterSeconds),
+		driftEnabled: tm.Drift.Enabled,
+		minPPM:       tm.Drift.MinPPM,
+		maxPPM:       tm.Drift.MaxPPM,
+		wanderPPM:    tm.Drift.WanderPPM,
+	}
+}
+
+// resolveProfile applies a profile's overrides to the default settings
+func resolveProfile(profile ManipulationProfile, m manipulation) (*manipulation, error) {
+	m.name = profile.Name
+
+	if profile.Honest {
+		zero := 0.0
+		m.honest = true
+		m.fixedOffset = &zero
+		m.jitterBound = 0
+		m.driftEnabled = false
+		return &m, nil
+	}
+
+	if profile.OffsetSeconds != nil && profile.InitialOffsetMinutes != nil {
+		return nil, fmt.Errorf("set either offset_seconds or initial_offset_minutes, not both")
+	}
+	if profile.OffsetSeconds != nil {
+		m.fixedOffset = profile.OffsetSeconds
+	}
+	if profile.InitialOffsetMinutes != nil {
+		if *profile.InitialOffsetMinutes < 0 {
+			return nil, fmt.Errorf("invalid initial offset: %d minutes (must not be negative)", *profile.InitialOffsetMinutes)
+		}
+		m.offsetBound = float64(*profile.InitialOffsetMinutes * 60)
+	}
+	if profile.JitterSeconds != nil {
+		if *profile.JitterSeconds < 0 {
+			return nil, fmt.Errorf("invalid jitter: %d seconds (must not be negative)", *profile.JitterSeconds)
+		}
+		m.jitterBound = float64(*profile.JitterSeconds)
+	}
+	if profile.DriftPPM != nil {
+		m.driftEnabled = true
+		m.minPPM = *profile.DriftPPM
+		m.maxPPM = *profile.DriftPPM
+	}
+	if profile.WanderPPM != nil {
+		if *profile.WanderPPM < 0 {
+			return nil, fmt.Errorf("invalid drift wander: %g (must not be negative)", *profile.WanderPPM)
+		}
+		// Wander alone walks from zero rather than from an inherited
+		// random frequency the profile never asked for
+		if profile.DriftPPM == nil {
+			m.minPPM, m.maxPPM = 0, 0
+		}
+		m.driftEnabled = true
+		m.wanderPPM = *profile.WanderPPM
+	}
+
+	return &m, nil
+}
+
+// Match returns the settings for a new client with the given key and address
+func (p *ProfileSet) Match(key string, address net.IP) *manipulation {
+	for _, rule := range p.rules {
+		if rule.keys[key] {
+			return rule.manipulation
+		}
+		if address == nil {
+			continue
+		}
+		for _, network := range rule.networks {
+			if network.Contains(address) {
+				return rule.manipulation
+			}
+		}
+	}
+	return p.defaults
+}
+
+// Get returns the settings of a named profile. Unknown names, such as
+// profiles removed from the configuration, fall back to the defaults.
+func (p *ProfileSet) Get(name string) *manipulation {
+	if m, ok := p.byName[name]; ok {
+		return m
+	}
+	return p.defaults
+}
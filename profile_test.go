@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestResolveProfileDrift(t *testing.T) {
+	wander, drift := 2.0, 50.0
+	defaults := manipulation{driftEnabled: true, minPPM: -100, maxPPM: 100}
+
+	tests := []struct {
+		name     string
+		profile  ManipulationProfile
+		defaults manipulation
+		min, max float64
+	}{
+		{"inherited", ManipulationProfile{}, defaults, -100, 100},
+		{"wander only", ManipulationProfile{WanderPPM: &wander}, defaults, 0, 0},
+		{"wander only, drift off", ManipulationProfile{WanderPPM: &wander},
+			manipulation{minPPM: -100, maxPPM: 100}, 0, 0},
+		{"wander and drift", ManipulationProfile{DriftPPM: &drift, WanderPPM: &wander}, defaults, 50, 50},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := resolveProfile(tt.profile, tt.defaults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !m.driftEnabled || m.minPPM != tt.min || m.maxPPM != tt.max {
+				t.Errorf("drift %v from %g to %g ppm, want %g to %g", m.driftEnabled, m.minPPM, m.maxPPM, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions for clients over the rate limit
+const (
+	RateLimitActionDrop = "drop" // Silently ignore the request
+	RateLimitActionKoD  = "kod"  // Answer with a RATE Kiss-o'-Death
+	RateLimitActionLog  = "log"  // Serve the request but log the violation
+)
+
+// tokenBucket tracks the request budget of one source
+type tokenBucket struct {
+	key      string
+	tokens   float64
+	last     time.Time
+	limiting bool // Currently over the limit
+	limited  uint64
+}
+
+// RateLimitStats is a snapshot of rate limiter counters
+type RateLimitStats struct {
+	TrackedSources  int    `json:"tracked_sources"`
+	LimitingSources int    `json:"limiting_sources"`
+	LimitedRequests uint64 `json:"limited_requests"`
+	LimitedSources  uint64 `json:"limited_sources"` // Distinct limiting episodes since startup
+	Evictions       uint64 `json:"evictions"`
+}
+
+// RateLimiter enforces a per-source token bucket. Memory is bounded by
+// evicting the least recently seen source once MaxTrackedSources is hit.
+type RateLimiter struct {
+	mu         sync.Mutex
+	enabled    bool
+	action     string
+	rate       float64 // Tokens per second
+	burst      float64
+	maxSources int
+
+	buckets map[string]*list.Element
+	lru     *list.List // Front = most recently seen
+
+	limitedRequests uint64
+	limitedSources  uint64
+	evictions       uint64
+}
+
+// NewRateLimiter builds the rate limiter from the security configuration
+func NewRateLimiter(config *Config) (*RateLimiter, error) {
+	rl := config.Security.RateLimit
+	limiter := &RateLimiter{
+		enabled:    rl.Enabled,
+		action:     strings.ToLower(rl.Action),
+		rate:       float64(rl.MaxRequestsPerMinute) / 60.0,
+		burst:      float64(rl.Burst),
+		maxSources: rl.MaxTrackedSources,
+		buckets:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+
+	switch limiter.action {
+	case RateLimitActionDrop, RateLimitActionKoD, RateLimitActionLog:
+	default:
+		return nil, fmt.Errorf("invalid rate_limit action %q (must be %s, %s or %s)",
+			rl.Action, RateLimitActionDrop, RateLimitActionKoD, RateLimitActionLog)
+	}
+	if rl.MaxRequestsPerMinute <= 0 {
+		return nil, fmt.Errorf("invalid max_requests_per_minute: %d (must be positive)", rl.MaxRequestsPerMinute)
+	}
+	if rl.Burst < 0 {
+		return nil, fmt.Errorf("invalid rate_limit burst: %d (must not be negative)", rl.Burst)
+	}
+	if limiter.burst == 0 {
+		limiter.burst = float64(rl.MaxRequestsPerMinute)
+	}
+	if limiter.maxSources <= 0 {
+		return nil, fmt.Errorf("invalid max_tracked_sources: %d (must be positive)", rl.MaxTrackedSources)
+	}
+
+	return limiter, nil
+}
+
+// Allow consumes a token for the source. It returns whether the request is
+// within the limit and whether this request started a new limiting episode.
+func (r *RateLimiter) Allow(key string) (bool, bool) {
+	if !r.enabled {
+		return true, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	bucket := r.bucket(key, now)
+
+	// Refill
+	bucket.tokens += now.Sub(bucket.last).Seconds() * r.rate
+	if bucket.tokens > r.burst {
+		bucket.tokens = r.burst
+	}
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.limiting = false
+		return true, false
+	}
+
+	started := !bucket.limiting
+	if started {
+		bucket.limiting = true
+		r.limitedSources++
+	}
+	bucket.limited++
+	r.limitedRequests++
+
+	return false, started
+}
+
+// bucket returns the source's bucket, creating it (and evicting the least
+// recently seen source if full) as needed. The caller must hold r.mu.
+func (r *RateLimiter) bucket(key string, now time.Time) *tokenBucket {
+	if elem, ok := r.buckets[key]; ok {
+		r.lru.MoveToFront(elem)
+		return elem.Value.(*tokenBucket)
+	}
+
+	for len(r.buckets) >= r.maxSources {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.buckets, oldest.Value.(*tokenBucket).key)
+		r.evictions++
+	}
+
+	bucket := &tokenBucket{key: key, tokens: r.burst, last: now}
+	r.buckets[key] = r.lru.PushFront(bucket)
+	return bucket
+}
+
+// Action returns what to do with requests over the limit
+func (r *RateLimiter) Action() string {
+	return r.action
+}
+
+// Stats returns a snapshot of the rate limiter counters
+func (r *RateLimiter) Stats() RateLimitStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := RateLimitStats{
+		TrackedSources:  len(r.buckets),
+		LimitedRequests: r.limitedRequests,
+		LimitedSources:  r.limitedSources,
+		Evictions:       r.evictions,
+	}
+	for _, elem := range r.buckets {
+		if elem.Value.(*tokenBucket).limiting {
+			stats.LimitingSources++
+		}
+	}
+
+	return stats
+}
@@ -0,0 +1,75 @@
+package main
+
+import "reflect"
+
+// Reload applies a new, already validated configuration without dropping
+// tracked client state. Settings bound at startup (listeners, workers,
+// persistence) keep their running values until the next restart.
+func (s *NTPServer) Reload(config *Config) error {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+
+	current := s.policies.Load()
+
+	for _, setting := range restartOnlyChanges(current.config, config) {
+		LogWarning("Reload: %s settings changed; restart to apply them", setting)
+	}
+	config.Server = current.config.Server
+	config.Persistence = current.config.Persistence
+	config.Admin = current.config.Admin
+	config.Metrics = current.config.Metrics
+	config.NTS = current.config.NTS
+	config.Broadcast = current.config.Broadcast
+	config.Symmetric = current.config.Symmetric
+	config.Legacy = current.config.Legacy
+	config.Roughtime = current.config.Roughtime
+	config.HTTPTime = current.config.HTTPTime
+
+	policies, err := newServerPolicies(config, current)
+	if err != nil {
+		return err
+	}
+	if err := ConfigureLogging(config); err != nil {
+		return err
+	}
+
+	s.tracker.UpdateConfig(config)
+	s.policies.Store(policies)
+	return nil
+}
+
+// restartOnlyChanges names the startup-bound sections that differ
+func restartOnlyChanges(old, updated *Config) []string {
+	var changed []string
+	if old.Server != updated.Server {
+		changed = append(changed, "server")
+	}
+	if old.Persistence != updated.Persistence {
+		changed = append(changed, "persistence")
+	}
+	if old.Admin != updated.Admin {
+		changed = append(changed, "admin")
+	}
+	if old.Metrics != updated.Metrics {
+		changed = append(changed, "metrics")
+	}
+	if old.NTS != updated.NTS {
+		changed = append(changed, "nts")
+	}
+	if !reflect.DeepEqual(old.Broadcast, updated.Broadcast) {
+		changed = append(changed, "broadcast")
+	}
+	if !reflect.DeepEqual(old.Symmetric, updated.Symmetric) {
+		changed = append(changed, "symmetric")
+	}
+	if old.Legacy != updated.Legacy {
+		changed = append(changed, "legacy")
+	}
+	if old.Roughtime != updated.Roughtime {
+		changed = append(changed, "roughtime")
+	}
+	if old.HTTPTime != updated.HTTPTime {
+		changed = append(changed, "http_time")
+	}
+	return changed
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Roughtime tags, as little-endian uint32s
+var (
+	tagSIG  = roughtimeTag("SIG\x00")
+	tagVER  = roughtimeTag("VER\x00")
+	tagPATH = roughtimeTag("PATH")
+	tagSREP = roughtimeTag("SREP")
+	tagCERT = roughtimeTag("CERT")
+	tagINDX = roughtimeTag("INDX")
+	tagNONC = roughtimeTag("NONC")
+	tagROOT = roughtimeTag("ROOT")
+	tagMIDP = roughtimeTag("MIDP")
+	tagRADI = roughtimeTag("RADI")
+	tagDELE = roughtimeTag("DELE")
+	tagPUBK = roughtimeTag("PUBK")
+	tagMINT = roughtimeTag("MINT")
+	tagMAXT = roughtimeTag("MAXT")
+)
+
+// Roughtime protocol constants
+const (
+	roughtimeMinRequest   = 1024 // Requests are padded to prevent amplification
+	roughtimeNonceSize    = 64
+	roughtimeResponseCtx  = "RoughTime v1 response signature\x00"
+	roughtimeDelegateCtx  = "RoughTime v1 delegation signature--\x00"
+	roughtimeTreeLeaf     = 0x00
+	roughtimeMaxRadiusSec = 4294 // RADI is a uint32 of microseconds
+)
+
+// IETF draft (draft-ietf-ntp-roughtime-08 to -11) constants. Versions are
+// 0x80000000 plus the draft number; Google Roughtime has no version.
+const (
+	roughtimeGoogle           = 0
+	roughtimeDraftMin         = 0x80000008
+	roughtimeDraftMax         = 0x8000000b
+	roughtimeDraftNonceSize   = 32
+	roughtimeDraftHashSize    = 32 // SHA-512 truncated to 256 bits
+	roughtimeDraftDelegateCtx = "RoughTime v1 delegation signature\x00"
+)
+
+// roughtimeMagic starts every IETF draft packet, followed by the
+// little-endian message length
+var roughtimeMagic = []byte("ROUGHTIM")
+
+// roughtimeTag converts a four-character tag to its wire value
+func roughtimeTag(s string) uint32 {
+	return binary.LittleEndian.Uint32([]byte(s))
+}
+
+// RoughtimeServer answers Google and IETF draft Roughtime requests with
+// signed, manipulated midpoints. Responses are signed by an online key
+// generated at startup and delegated by the configured long-term Ed25519
+// key.
+type RoughtimeServer struct {
+	server     *NTPServer
+	addr       string
+	radius     uint32 // Microseconds
+	radiusSec  uint32 // Whole seconds, for the drafts
+	publicKey  ed25519.PublicKey
+	online     ed25519.PrivateKey
+	googleCert []byte
+	draftCert  []byte
+	conn       *net.UDPConn
+}
+
+// NewRoughtimeServer loads the long-term key and creates the delegation
+func NewRoughtimeServer(config *Config, server *NTPServer) (*RoughtimeServer, error) {
+	rc := config.Roughtime
+	longTerm, err := roughtimeLongTermKey(config)
+	if err != nil {
+		return nil, err
+	}
+	onlinePublic, online, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate roughtime online key: %w", err)
+	}
+
+	// The delegations never expire, so any midpoint, however manipulated,
+	// falls inside them. The drafts use a different signature context.
+	dele := encodeRoughtime(map[uint32][]byte{
+		tagPUBK: onlinePublic,
+		tagMINT: binary.LittleEndian.AppendUint64(nil, 0),
+		tagMAXT: binary.LittleEndian.AppendUint64(nil, math.MaxUint64),
+	})
+
+	return &RoughtimeServer{
+		server:     server,
+		addr:       net.JoinHostPort(rc.Host, strconv.Itoa(rc.Port)),
+		radius:     uint32(rc.RadiusSeconds * 1e6),
+		radiusSec:  uint32(math.Ceil(rc.RadiusSeconds)),
+		publicKey:  longTerm.Public().(ed25519.PublicKey),
+		online:     online,
+		googleCert: roughtimeCert(longTerm, roughtimeDelegateCtx, dele),
+		draftCert:  roughtimeCert(longTerm, roughtimeDraftDelegateCtx, dele),
+	}, nil
+}
+
+// validateRoughtime checks the Roughtime settings without generating keys
+func validateRoughtime(config *Config) error {
+	_, err := roughtimeLongTermKey(config)
+	return err
+}
+
+// roughtimeLongTermKey checks the Roughtime settings and decodes the
+// long-term key
+func roughtimeLongTermKey(config *Config) (ed25519.PrivateKey, error) {
+	rc := config.Roughtime
+	if rc.Port < 1 || rc.Port > 65535 {
+		return nil, fmt.Errorf("invalid roughtime port: %d (must be 1-65535)", rc.Port)
+	}
+	if rc.RadiusSeconds <= 0 || rc.RadiusSeconds > roughtimeMaxRadiusSec {
+		return nil, fmt.Errorf("invalid roughtime radius: %g (must be 0-%d seconds)", rc.RadiusSeconds, roughtimeMaxRadiusSec)
+	}
+
+	longTerm, err := parseEd25519Key(rc.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if longTerm == nil {
+		return nil, errors.New("roughtime requires a private_key so clients can pin its public key")
+	}
+	return longTerm, nil
+}
+
+// roughtimeCert signs a delegation with the long-term key
+func roughtimeCert(longTerm ed25519.PrivateKey, context string, dele []byte) []byte {
+	return encodeRoughtime(map[uint32][]byte{
+		tagSIG:  ed25519.Sign(longTerm, append([]byte(context), dele...)),
+		tagDELE: dele,
+	})
+}
+
+// parseEd25519Key decodes a hex or base64 Ed25519 seed (32 bytes) or
+// private key (64 bytes). An empty string returns nil.
+func parseEd25519Key(s string) (ed25519.PrivateKey, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		if key, err = base64.StdEncoding.DecodeString(s); err != nil {
+			return nil, errors.New("invalid roughtime private key: must be hex or base64")
+		}
+	}
+	switch len(key) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(key), nil
+	}
+	return nil, fmt.Errorf("invalid roughtime private key: %d bytes (must be %d or %d)",
+		len(key), ed25519.SeedSize, ed25519.PrivateKeySize)
+}
+
+// Start serves Roughtime until Stop is called
+func (r *RoughtimeServer) Start() error {
+	udpAddr, err := net.ResolveUDPAddr("udp", r.addr)
+	if err != nil {
+		return fmt.Errorf("failed to resolve roughtime address %s: %w", r.addr, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for roughtime: %w", err)
+	}
+	r.conn = conn
+
+	LogInfo("Roughtime listening on %s (public key %s)", r.addr, base64.StdEncoding.EncodeToString(r.publicKey))
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			LogWarning("Roughtime read failed: %v", err)
+			continue
+		}
+		if response := r.respond(buf[:n], addr); response != nil {
+			if _, err := conn.WriteToUDP(response, addr); err != nil {
+				LogDebug("Error sending roughtime response to %s: %v", addr.String(), err)
+			}
+		}
+	}
+}
+
+// Stop closes the Roughtime socket
+func (r *RoughtimeServer) Stop() error {
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.Close()
+}
+
+// respond builds the signed response to one request, or nil to drop it
+func (r *RoughtimeServer) respond(request []byte, addr *net.UDPAddr) []byte {
+	s := r.server
+	p := s.policies.Load()
+	if !s.admit(p, addr, "roughtime") {
+		return nil
+	}
+
+	if len(request) < roughtimeMinRequest {
+		s.metrics.RecordResponse(OutcomeDropped, "malformed")
+		return nil
+	}
+	version, nonce, err := parseRoughtimeRequest(request)
+	if err != nil {
+		LogDebug("Dropping roughtime request from %s: %v", addr.String(), err)
+		s.metrics.RecordResponse(OutcomeDropped, "malformed")
+		return nil
+	}
+
+	rxTime := time.Now()
+	clientKey := p.keyer.KeyForHost(addr.IP)
+	manipulatedTime, offset, isInitial := s.tracker.GetManipulatedTime(clientKey, addr.IP, rxTime)
+	servedTime, _ := p.leap.Apply(manipulatedTime)
+	response := r.reply(version, nonce, servedTime)
+
+	s.metrics.RecordResponse(OutcomeServed, "roughtime")
+	if p.config.Logging.LogTransactions {
+		r.logRequest(addr, offset, isInitial, manipulatedTime)
+	}
+	return response
+}
+
+// parseRoughtimeRequest returns the version to answer with and the nonce.
+// Packets starting with the ROUGHTIM magic are IETF draft requests, which
+// list the versions the client supports; anything else is Google framing.
+func parseRoughtimeRequest(request []byte) (uint32, []byte, error) {
+	if !bytes.HasPrefix(request, roughtimeMagic) {
+		tags, err := decodeRoughtime(request)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(tags[tagNONC]) != roughtimeNonceSize {
+			return 0, nil, fmt.Errorf("invalid roughtime nonce: %d bytes (must be %d)", len(tags[tagNONC]), roughtimeNonceSize)
+		}
+		return roughtimeGoogle, tags[tagNONC], nil
+	}
+
+	msg, err := unframeRoughtime(request)
+	if err != nil {
+		return 0, nil, err
+	}
+	tags, err := decodeRoughtime(msg)
+	if err != nil {
+		return 0, nil, err
+	}
+	versions := tags[tagVER]
+	if len(versions) == 0 || len(versions)%4 != 0 {
+		return 0, nil, errors.New("roughtime draft request without a version list")
+	}
+	version := uint32(0)
+	for i := 0; i < len(versions); i += 4 {
+		if v := binary.LittleEndian.Uint32(versions[i:]); v >= roughtimeDraftMin && v <= roughtimeDraftMax && v > version {
+			version = v
+		}
+	}
+	if version == 0 {
+		return 0, nil, fmt.Errorf("no supported roughtime version (must be draft %d-%d)",
+			roughtimeDraftMin&0xff, roughtimeDraftMax&0xff)
+	}
+	if len(tags[tagNONC]) != roughtimeDraftNonceSize {
+		return 0, nil, fmt.Errorf("invalid roughtime nonce: %d bytes (must be %d)", len(tags[tagNONC]), roughtimeDraftNonceSize)
+	}
+	return version, tags[tagNONC], nil
+}
+
+// reply signs a response carrying midpoint for a batch of one nonce, so
+// the Merkle root is the nonce's leaf hash
+func (r *RoughtimeServer) reply(version uint32, nonce []byte, midpoint time.Time) []byte {
+	leaf := sha512.Sum512(append([]byte{roughtimeTreeLeaf}, nonce...))
+
+	if version == roughtimeGoogle {
+		srep := encodeRoughtime(map[uint32][]byte{
+			tagRADI: binary.LittleEndian.AppendUint32(nil, r.radius),
+			tagMIDP: binary.LittleEndian.AppendUint64(nil, uint64(midpoint.UnixMicro())),
+			tagROOT: leaf[:],
+		})
+		return encodeRoughtime(map[uint32][]byte{
+			tagSIG:  ed25519.Sign(r.online, append([]byte(roughtimeResponseCtx), srep...)),
+			tagPATH: {},
+			tagSREP: srep,
+			tagCERT: r.googleCert,
+			tagINDX: binary.LittleEndian.AppendUint32(nil, 0),
+		})
+	}
+
+	// The drafts count whole seconds and echo the version and nonce
+	srep := encodeRoughtime(map[uint32][]byte{
+		tagRADI: binary.LittleEndian.AppendUint32(nil, r.radiusSec),
+		tagMIDP: binary.LittleEndian.AppendUint64(nil, uint64(midpoint.Unix())),
+		tagROOT: leaf[:roughtimeDraftHashSize],
+	})
+	return frameRoughtime(encodeRoughtime(map[uint32][]byte{
+		tagSIG:  ed25519.Sign(r.online, append([]byte(roughtimeResponseCtx), srep...)),
+		tagVER:  binary.LittleEndian.AppendUint32(nil, version),
+		tagNONC: nonce,
+		tagPATH: {},
+		tagSREP: srep,
+		tagCERT: r.draftCert,
+		tagINDX: binary.LittleEndian.AppendUint32(nil, 0),
+	}))
+}
+
+// logRequest logs a Roughtime transaction
+func (r *RoughtimeServer) logRequest(addr *net.UDPAddr, offset float64, isInitial bool, manipulatedTime time.Time) {
+	log := TransactionLog{
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Event:       "roughtime_request",
+		RequestType: "udp",
+	}
+
+	log.Client.IP = addr.IP.String()
+	log.Client.Port = addr.Port
+	log.Client.IsNew = isInitial
+
+	log.Response.ActualTime = time.Now().UTC().Format(time.RFC3339Nano)
+	log.Response.OffsetSeconds = offset
+	log.Response.OffsetMinutes = offset / 60.0
+	log.Response.ManipulatedTime = manipulatedTime.UTC().Format(time.RFC3339Nano)
+
+	manipulation := r.server.tracker.Config().TimeManipulation
+	log.Config.NMinutes = manipulation.InitialOffsetMinutes
+	log.Config.XSeconds = manipulation.JitterSeconds
+	log.Config.Stratum = r.server.Config().NTP.Stratum
+
+	r.server.writeTransaction(&log, fmt.Sprintf("roughtime request from %s - offset: %.1f sec (%.2f min)",
+		addr.String(), log.Response.OffsetSeconds, log.Response.OffsetMinutes))
+}
+
+// encodeRoughtime encodes a Roughtime message: the tag count, the value
+// offsets, the tags in ascending order, then the values. Values must be a
+// multiple of 4 bytes long.
+func encodeRoughtime(values map[uint32][]byte) []byte {
+	tags := make([]uint32, 0, len(values))
+	for tag := range values {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	b := binary.LittleEndian.AppendUint32(nil, uint32(len(tags)))
+	offset := 0
+	for i, tag := range tags {
+		if i > 0 {
+			b = binary.LittleEndian.AppendUint32(b, uint32(offset))
+		}
+		offset += len(values[tag])
+	}
+	for _, tag := range tags {
+		b = binary.LittleEndian.AppendUint32(b, tag)
+	}
+	for _, tag := range tags {
+		b = append(b, values[tag]...)
+	}
+	return b
+}
+
+// frameRoughtime wraps a message in the IETF draft packet header
+func frameRoughtime(msg []byte) []byte {
+	b := append([]byte(nil), roughtimeMagic...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(msg)))
+	return append(b, msg...)
+}
+
+// unframeRoughtime strips the IETF draft packet header. Bytes past the
+// declared length are ignored.
+func unframeRoughtime(packet []byte) ([]byte, error) {
+	if len(packet) < len(roughtimeMagic)+4 || !bytes.HasPrefix(packet, roughtimeMagic) {
+		return nil, errors.New("invalid roughtime packet header")
+	}
+	length := binary.LittleEndian.Uint32(packet[8:12])
+	if uint64(length) > uint64(len(packet)-12) {
+		return nil, fmt.Errorf("roughtime packet length %d exceeds the %d bytes received", length, len(packet)-12)
+	}
+	return packet[12 : 12+length], nil
+}
+
+// decodeRoughtime parses a Roughtime message into its tagged values
+func decodeRoughtime(msg []byte) (map[uint32][]byte, error) {
+	if len(msg) < 4 || len(msg)%4 != 0 {
+		return nil, errors.New("invalid roughtime message length")
+	}
+	count := int(binary.LittleEndian.Uint32(msg))
+	headerLen := 4 + 8*count - 4
+	if count == 0 || count > 1024 || headerLen > len(msg) {
+		return nil, fmt.Errorf("invalid roughtime tag count: %d", count)
+	}
+
+	values := msg[headerLen:]
+	tags := make(map[uint32][]byte, count)
+	start, previous := 0, uint32(0)
+	for i := 0; i < count; i++ {
+		end := len(values)
+		if i < count-1 {
+			end = int(binary.LittleEndian.Uint32(msg[4+4*i:]))
+		}
+		tag := binary.LittleEndian.Uint32(msg[4+4*(count-1)+4*i:])
+		if end < start || end > len(values) || end%4 != 0 || (i > 0 && tag <= previous) {
+			return nil, errors.New("invalid roughtime message header")
+		}
+		tags[tag] = values[start:end]
+		start, previous = end, tag
+	}
+	return tags, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha512"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestRoughtimeRoundTrip(t *testing.T) {
+	values := map[uint32][]byte{
+		tagNONC: bytes.Repeat([]byte{0x42}, 64),
+		tagPATH: {},
+		tagINDX: {1, 0, 0, 0},
+		tagSIG:  bytes.Repeat([]byte{0x07}, 64),
+	}
+	decoded, err := decodeRoughtime(encodeRoughtime(values))
+	if err != nil {
+		t.Fatalf("decodeRoughtime: %v", err)
+	}
+	if len(decoded) != len(values) {
+		t.Fatalf("got %d tags, want %d", len(decoded), len(values))
+	}
+	for tag, value := range values {
+		if !bytes.Equal(decoded[tag], value) {
+			t.Errorf("tag %08x = %x, want %x", tag, decoded[tag], value)
+		}
+	}
+}
+
+func TestDecodeRoughtimeMalformed(t *testing.T) {
+	// header builds a message from a tag count, offsets and tags
+	header := func(words ...uint32) []byte {
+		var b []byte
+		for _, w := range words {
+			b = binary.LittleEndian.AppendUint32(b, w)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{"empty", nil},
+		{"unaligned", []byte{1, 0, 0, 0, 0}},
+		{"zero tags", header(0)},
+		{"too many tags", header(2000)},
+		{"header past end", header(3, 4, 8)},
+		{"offset past end", append(header(2, 64, tagNONC, tagPATH), make([]byte, 8)...)},
+		{"unaligned offset", append(header(2, 2, tagNONC, tagPATH), make([]byte, 8)...)},
+		{"decreasing offsets", append(header(3, 8, 4, tagSIG, tagNONC, tagPATH), make([]byte, 8)...)},
+		{"unsorted tags", append(header(2, 4, tagPATH, tagNONC), make([]byte, 8)...)},
+		{"repeated tag", append(header(2, 4, tagNONC, tagNONC), make([]byte, 8)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tags, err := decodeRoughtime(tt.msg); err == nil {
+				t.Errorf("decodeRoughtime = %d tags, want error", len(tags))
+			}
+		})
+	}
+}
+
+// roughtimeRequest pads a message to the minimum request size
+func roughtimeRequest(values map[uint32][]byte, framed bool) []byte {
+	overhead := 4 + 8*(len(values)+1)
+	if framed {
+		overhead += 12
+	}
+	for _, v := range values {
+		overhead += len(v)
+	}
+	values[roughtimeTag("ZZZZ")] = make([]byte, roughtimeMinRequest-overhead)
+	msg := encodeRoughtime(values)
+	if framed {
+		return frameRoughtime(msg)
+	}
+	return msg
+}
+
+func TestParseRoughtimeRequest(t *testing.T) {
+	versions := func(vs ...uint32) []byte {
+		var b []byte
+		for _, v := range vs {
+			b = binary.LittleEndian.AppendUint32(b, v)
+		}
+		return b
+	}
+	googleNonce := bytes.Repeat([]byte{1}, roughtimeNonceSize)
+	draftNonce := bytes.Repeat([]byte{2}, roughtimeDraftNonceSize)
+	truncated := frameRoughtime(make([]byte, 16))
+	binary.LittleEndian.PutUint32(truncated[8:], 4096)
+
+	tests := []struct {
+		name    string
+		request []byte
+		version uint32
+		wantErr bool
+	}{
+		{"google", roughtimeRequest(map[uint32][]byte{tagNONC: googleNonce}, false), roughtimeGoogle, false},
+		{"google short nonce", roughtimeRequest(map[uint32][]byte{tagNONC: draftNonce}, false), 0, true},
+		{"draft", roughtimeRequest(map[uint32][]byte{tagVER: versions(0x8000000b), tagNONC: draftNonce}, true),
+			0x8000000b, false},
+		{"draft picks newest", roughtimeRequest(map[uint32][]byte{
+			tagVER: versions(0x80000007, 0x80000008, 0x8000000a, 0x8000000c), tagNONC: draftNonce}, true),
+			0x8000000a, false},
+		{"draft unsupported", roughtimeRequest(map[uint32][]byte{tagVER: versions(0x80000007), tagNONC: draftNonce}, true),
+			0, true},
+		{"draft without VER", roughtimeRequest(map[uint32][]byte{tagNONC: draftNonce}, true), 0, true},
+		{"draft long nonce", roughtimeRequest(map[uint32][]byte{tagVER: versions(0x8000000b), tagNONC: googleNonce}, true),
+			0, true},
+		{"draft length past end", truncated, 0, true},
+		{"magic only", []byte("ROUGHTIM"), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, nonce, err := parseRoughtimeRequest(tt.request)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseRoughtimeRequest = version %08x, want error", version)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseRoughtimeRequest: %v", err)
+			}
+			if version != tt.version || len(nonce) == 0 {
+				t.Errorf("version %08x with %d byte nonce, want %08x", version, len(nonce), tt.version)
+			}
+		})
+	}
+}
+
+func TestRoughtimeReply(t *testing.T) {
+	config := &Config{}
+	config.Roughtime.Port = 2002
+	config.Roughtime.RadiusSeconds = 1.5
+	config.Roughtime.PrivateKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	r, err := NewRoughtimeServer(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	midpoint := time.Date(2030, 1, 2, 3, 4, 5, 6000, time.UTC)
+
+	tests := []struct {
+		name     string
+		version  uint32
+		nonce    []byte
+		context  string
+		midp     uint64
+		radius   uint32
+		rootSize int
+	}{
+		{"google", roughtimeGoogle, bytes.Repeat([]byte{1}, 64), roughtimeDelegateCtx,
+			uint64(midpoint.UnixMicro()), 1500000, 64},
+		{"draft", 0x8000000b, bytes.Repeat([]byte{2}, 32), roughtimeDraftDelegateCtx,
+			uint64(midpoint.Unix()), 2, 32},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := r.reply(tt.version, tt.nonce, midpoint)
+			if tt.version != roughtimeGoogle {
+				if msg, err = unframeRoughtime(msg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			resp, err := decodeRoughtime(msg)
+			if err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			cert, err := decodeRoughtime(resp[tagCERT])
+			if err != nil {
+				t.Fatalf("decode CERT: %v", err)
+			}
+			if !ed25519.Verify(r.publicKey, append([]byte(tt.context), cert[tagDELE]...), cert[tagSIG]) {
+				t.Error("delegation signature does not verify")
+			}
+			dele, err := decodeRoughtime(cert[tagDELE])
+			if err != nil {
+				t.Fatalf("decode DELE: %v", err)
+			}
+			if !ed25519.Verify(dele[tagPUBK], append([]byte(roughtimeResponseCtx), resp[tagSREP]...), resp[tagSIG]) {
+				t.Error("response signature does not verify")
+			}
+
+			srep, err := decodeRoughtime(resp[tagSREP])
+			if err != nil {
+				t.Fatalf("decode SREP: %v", err)
+			}
+			leaf := sha512.Sum512(append([]byte{roughtimeTreeLeaf}, tt.nonce...))
+			if !bytes.Equal(srep[tagROOT], leaf[:tt.rootSize]) {
+				t.Errorf("ROOT = %x, want %x", srep[tagROOT], leaf[:tt.rootSize])
+			}
+			if got := binary.LittleEndian.Uint64(srep[tagMIDP]); got != tt.midp {
+				t.Errorf("MIDP = %d, want %d", got, tt.midp)
+			}
+			if got := binary.LittleEndian.Uint32(srep[tagRADI]); got != tt.radius {
+				t.Errorf("RADI = %d, want %d", got, tt.radius)
+			}
+			if tt.version != roughtimeGoogle && !bytes.Equal(resp[tagNONC], tt.nonce) {
+				t.Errorf("NONC = %x, want the request nonce", resp[tagNONC])
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// rxOOBSize is large enough for one SCM_TIMESTAMPNS control message
+var rxOOBSize = syscall.CmsgSpace(int(unsafe.Sizeof(syscall.Timespec{})))
+
+// enableRxTimestamps asks the kernel to timestamp received packets
+// (SO_TIMESTAMPNS) so T2 reflects arrival rather than processing time
+func enableRxTimestamps(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
+	})
+	if err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("setsockopt SO_TIMESTAMPNS: %w", sockErr)
+	}
+
+	return nil
+}
+
+// readPacket reads one datagram and its receive time. The kernel timestamp
+// is used when present; otherwise the time is taken right after the read.
+func readPacket(conn *net.UDPConn, buf, oob []byte) (int, *net.UDPAddr, time.Time, error) {
+	n, oobn, _, addr, err := conn.ReadMsgUDP(buf, oob)
+	rxTime := time.Now()
+	if err != nil {
+		return 0, nil, rxTime, err
+	}
+
+	if oobn > 0 {
+		if kernelTime, ok := parseRxTimestamp(oob[:oobn]); ok {
+			rxTime = kernelTime
+		}
+	}
+
+	return n, addr, rxTime, nil
+}
+
+// parseRxTimestamp extracts an SCM_TIMESTAMPNS timestamp from control data
+func parseRxTimestamp(oob []byte) (time.Time, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	for _, msg := range msgs {
+		if msg.Header.Level != syscall.SOL_SOCKET || msg.Header.Type != syscall.SCM_TIMESTAMPNS {
+			continue
+		}
+		if len(msg.Data) < int(unsafe.Sizeof(syscall.Timespec{})) {
+			continue
+		}
+		ts := (*syscall.Timespec)(unsafe.Pointer(&msg.Data[0]))
+		return time.Unix(ts.Unix()), true
+	}
+
+	return time.Time{}, false
+}
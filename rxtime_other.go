@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// rxOOBSize is zero where kernel receive timestamps are unsupported
+var rxOOBSize = 0
+
+// enableRxTimestamps is only supported on Linux
+func enableRxTimestamps(conn *net.UDPConn) error {
+	return errors.New("kernel receive timestamps are not supported on this platform")
+}
+
+// readPacket reads one datagram, timestamping it right after the read
+func readPacket(conn *net.UDPConn, buf, oob []byte) (int, *net.UDPAddr, time.Time, error) {
+	n, addr, err := conn.ReadFromUDP(buf)
+	return n, addr, time.Now(), err
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scenario phase types
+const (
+	PhaseChaos  = "chaos"  // Random initial offset on entry, then drift and jitter
+	PhaseHonest = "honest" // Serve the real time
+	PhaseOffset = "offset" // Hold a fixed offset from the real time
+	PhaseStep   = "step"   // Step the clock once on entry, then free-run
+	PhaseFreeze = "freeze" // Stop the clock
+	PhaseDrift  = "drift"  // Run fast or slow at a fixed rate
+	PhaseHeal   = "heal"   // Slew (or step) back to the real time
+)
+
+// Scenario clock origins
+const (
+	ScenarioClockGlobal = "global" // Timeline starts when the daemon starts
+	ScenarioClockClient = "client" // Timeline starts at each client's first request
+)
+
+var scenarioPhaseTypes = []string{PhaseChaos, PhaseHonest, PhaseOffset, PhaseStep, PhaseFreeze, PhaseDrift, PhaseHeal}
+
+// Scenario is a time-scripted sequence of manipulation phases
+type Scenario struct {
+	Name   string          `yaml:"name"`
+	Clock  string          `yaml:"clock"`
+	Loop   bool            `yaml:"loop"`
+	Phases []ScenarioPhase `yaml:"phases"`
+}
+
+// ScenarioPhase is a single stage of a scenario
+type ScenarioPhase struct {
+	Name          string        `yaml:"name"`
+	Type          string        `yaml:"type"`
+	Duration      time.Duration `yaml:"duration"` // 0 on the last phase = forever
+	OffsetSeconds float64       `yaml:"offset_seconds"`
+	StepSeconds   float64       `yaml:"step_seconds"`
+	DriftPPM      float64       `yaml:"drift_ppm"`
+	SlewPPM       float64       `yaml:"slew_ppm"` // heal rate; 0 = step back immediately
+	JitterSeconds float64       `yaml:"jitter_seconds"`
+}
+
+// LoadScenario reads a scenario from a YAML file
+func LoadScenario(path string) (Scenario, error) {
+	var scenario Scenario
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return scenario, fmt.Errorf("error reading scenario file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &scenario); err != nil {
+		return scenario, fmt.Errorf("error parsing scenario file: %w", err)
+	}
+
+	return scenario, nil
+}
+
+// Enabled reports whether the scenario has any phases
+func (sc *Scenario) Enabled() bool {
+	return len(sc.Phases) > 0
+}
+
+// Validate checks the scenario for unknown types and impossible timelines
+func (sc *Scenario) Validate() error {
+	if sc.Clock == "" {
+		sc.Clock = ScenarioClockGlobal
+	}
+	if sc.Clock != ScenarioClockGlobal && sc.Clock != ScenarioClockClient {
+		return fmt.Errorf("invalid scenario clock %q (must be %s or %s)", sc.Clock, ScenarioClockGlobal, ScenarioClockClient)
+	}
+
+	for i := range sc.Phases {
+		phase := &sc.Phases[i]
+		phase.Type = strings.ToLower(phase.Type)
+		if phase.Name == "" {
+			phase.Name = fmt.Sprintf("%s-%d", phase.Type, i+1)
+		}
+
+		known := false
+		for _, t := range scenarioPhaseTypes {
+			if phase.Type == t {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("phase %d: unknown type %q (must be one of: %s)",
+				i+1, phase.Type, strings.Join(scenarioPhaseTypes, ", "))
+		}
+
+		if phase.Duration < 0 {
+			return fmt.Errorf("phase %d (%s): duration must not be negative", i+1, phase.Name)
+		}
+		if phase.Duration == 0 && (sc.Loop || i < len(sc.Phases)-1) {
+			return fmt.Errorf("phase %d (%s): duration is required unless it is the last phase of a non-looping scenario",
+				i+1, phase.Name)
+		}
+		if phase.SlewPPM < 0 {
+			return fmt.Errorf("phase %d (%s): slew_ppm must not be negative", i+1, phase.Name)
+		}
+		if phase.JitterSeconds < 0 {
+			return fmt.Errorf("phase %d (%s): jitter_seconds must not be negative", i+1, phase.Name)
+		}
+	}
+
+	return nil
+}
+
+// cycleLength returns the duration of one pass through all phases
+func (sc *Scenario) cycleLength() time.Duration {
+	var total time.Duration
+	for _, phase := range sc.Phases {
+		total += phase.Duration
+	}
+	return total
+}
+
+// phaseAt locates the phase active at the given scenario time. It returns
+// a sequence number that increases with every phase entered (including
+// repeats when looping), the phase index and how long until it ends.
+// A remaining time of -1 means the phase never ends.
+func (sc *Scenario) phaseAt(elapsed time.Duration) (seq, index int, remaining time.Duration) {
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	cycle := 0
+	if sc.Loop {
+		length := sc.cycleLength()
+		cycle = int(elapsed / length)
+		elapsed %= length
+	}
+
+	var start time.Duration
+	for i, phase := range sc.Phases {
+		last := i == len(sc.Phases)-1
+		if phase.Duration == 0 {
+			return cycle*len(sc.Phases) + i, i, -1
+		}
+		if elapsed < start+phase.Duration {
+			return cycle*len(sc.Phases) + i, i, start + phase.Duration - elapsed
+		}
+		if last && !sc.Loop {
+			// Past the end of a non-looping timeline: the last phase persists
+			return i, i, -1
+		}
+		start += phase.Duration
+	}
+
+	// Unreachable for validated scenarios
+	last := len(sc.Phases) - 1
+	return cycle*len(sc.Phases) + last, last, -1
+}
+
+// scenarioOrigin returns the start of the scenario timeline for a client
+func (t *ClientTimeTracker) scenarioOrigin(state *ClientState) time.Time {
+	if t.scenario.Clock == ScenarioClockClient {
+		return state.FirstSeen
+	}
+	return t.startTime
+}
+
+// advanceScenario runs the client's manipulated clock forward from its last
+// request to now, phase by phase, applying each phase's entry effect once
+func (t *ClientTimeTracker) advanceScenario(state *ClientState, now time.Time) {
+	sc := t.scenario
+	origin := t.scenarioOrigin(state)
+
+	cur := state.LastActualTime
+	offset := state.LastManipulatedTime.Sub(state.LastActualTime)
+
+	for {
+		seq, index, remaining := sc.phaseAt(cur.Sub(origin))
+		phase := sc.Phases[index]
+
+		if seq != state.PhaseSeq {
+			offset = t.enterPhase(state, phase, offset)
+			state.PhaseSeq = seq
+			state.Phase = phase.Name
+			t.logPhaseChange(seq, index, phase)
+		}
+
+		if !cur.Before(now) {
+			break
+		}
+
+		segment := now.Sub(cur)
+		if remaining >= 0 && remaining < segment {
+			segment = remaining
+		}
+		offset = t.evolvePhase(state, phase, offset, segment)
+		cur = cur.Add(segment)
+	}
+
+	state.LastActualTime = now
+	state.LastManipulatedTime = now.Add(offset)
+}
+
+// enterPhase applies a phase's one-time effect to the client's offset
+func (t *ClientTimeTracker) enterPhase(state *ClientState, phase ScenarioPhase, offset time.Duration) time.Duration {
+	switch phase.Type {
+	case PhaseChaos:
+		return secondsToDuration(t.initialOffset(t.profiles.Get(state.Profile)))
+	case PhaseHonest:
+		return 0
+	case PhaseOffset:
+		return secondsToDuration(phase.OffsetSeconds)
+	case PhaseStep:
+		return offset + secondsToDuration(phase.StepSeconds)
+	case PhaseHeal:
+		if phase.SlewPPM == 0 {
+			return 0
+		}
+	}
+	return offset
+}
+
+// evolvePhase advances the client's offset through part of a phase
+func (t *ClientTimeTracker) evolvePhase(state *ClientState, phase ScenarioPhase, offset, elapsed time.Duration) time.Duration {
+	switch phase.Type {
+	case PhaseHonest:
+		return 0
+	case PhaseOffset:
+		return secondsToDuration(phase.OffsetSeconds)
+	case PhaseFreeze:
+		return offset - elapsed
+	case PhaseDrift:
+		return offset + scaleByFrequency(elapsed, phase.DriftPPM) - elapsed
+	case PhaseHeal:
+		step := time.Duration(float64(elapsed) * phase.SlewPPM / 1e6)
+		if offset > 0 {
+			return maxDuration(offset-step, 0)
+		}
+		return -maxDuration(-offset-step, 0)
+	}
+
+	// chaos and step phases free-run on the client's own oscillator
+	t.wanderFrequency(state, elapsed)
+	return offset + scaleByFrequency(elapsed, state.FrequencyPPM) - elapsed
+}
+
+// phaseJitter returns the jitter bound in seconds for a client in a phase
+func (t *ClientTimeTracker) phaseJitter(state *ClientState, phase ScenarioPhase) float64 {
+	if phase.Type == PhaseChaos && phase.JitterSeconds == 0 {
+		return t.profiles.Get(state.Profile).jitterBound
+	}
+	return phase.JitterSeconds
+}
+
+// logPhaseChange reports transitions of the global scenario timeline
+func (t *ClientTimeTracker) logPhaseChange(seq, index int, phase ScenarioPhase) {
+	if t.scenario.Clock != ScenarioClockGlobal || seq <= t.scenarioSeq {
+		return
+	}
+	t.scenarioSeq = seq
+	LogInfo("Scenario phase %d/%d started: %s (%s)", index+1, len(t.scenario.Phases), phase.Name, phase.Type)
+}
+
+// secondsToDuration converts floating-point seconds to a Duration
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Round(seconds * float64(time.Second)))
+}
+
+// maxDuration returns the larger of two durations
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
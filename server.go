@@ -2,42 +2,71 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // NTPServer represents the UDP NTP server
 type NTPServer struct {
-	config  *Config
-	tracker *ClientTimeTracker
-	conn    *net.UDPConn
+	policies atomic.Pointer[serverPolicies]
+	tracker  *ClientTimeTracker
+	nts      *NTS
+	peers    *PeerTable
+	metrics  *Metrics
+	pipeline *pipeline
+	conn     *net.UDPConn
+
+	configMu sync.Mutex // Serializes reloads and admin updates
+}
+
+// serverPolicies are the request-handling components built from one
+// configuration. Reload replaces them as a unit.
+type serverPolicies struct {
+	config     *Config
+	keyer      *ClientKeyer
+	kod        *KoDPolicy
+	acl        *AccessList
+	limiter    *RateLimiter
+	leap       *LeapSimulator
+	auth       *Authenticator
+	extensions *ExtensionInjector
+	control    *ControlResponder
 }
 
 // TransactionLog represents a transaction log entry
 type TransactionLog struct {
-	Timestamp   string  `json:"timestamp"`
-	Event       string  `json:"event"`
-	RequestType string  `json:"request_type"`
+	Timestamp   string `json:"timestamp"`
+	Event       string `json:"event"`
+	RequestType string `json:"request_type"`
+	Reason      string `json:"reason,omitempty"`
 	Client      struct {
-		IP     string `json:"ip"`
-		Port   int    `json:"port"`
-		IsNew  bool   `json:"is_new"`
+		IP    string `json:"ip"`
+		Port  int    `json:"port"`
+		IsNew bool   `json:"is_new"`
 	} `json:"client"`
 	Request struct {
-		Version          int    `json:"version"`
-		Mode             int    `json:"mode"`
+		Version           int    `json:"version"`
+		Mode              int    `json:"mode"`
 		TransmitTimestamp string `json:"transmit_timestamp"`
 	} `json:"request"`
 	Response struct {
-		Stratum              int     `json:"stratum"`
-		ReferenceID          string  `json:"reference_id"`
-		ActualTime           string  `json:"actual_time"`
-		OffsetSeconds        float64 `json:"offset_seconds"`
-		OffsetMinutes        float64 `json:"offset_minutes"`
-		ManipulatedTime      string  `json:"manipulated_time"`
-		ElapsedSeconds       float64 `json:"elapsed_seconds,omitempty"`
-		JitterApplied        float64 `json:"jitter_applied,omitempty"`
+		Stratum         int      `json:"stratum"`
+		ReferenceID     string   `json:"reference_id"`
+		ActualTime      string   `json:"actual_time"`
+		OffsetSeconds   float64  `json:"offset_seconds"`
+		OffsetMinutes   float64  `json:"offset_minutes"`
+		ManipulatedTime string   `json:"manipulated_time"`
+		ElapsedSeconds  float64  `json:"elapsed_seconds,omitempty"`
+		JitterApplied   float64  `json:"jitter_applied,omitempty"`
+		KissCode        string   `json:"kiss_code,omitempty"`
+		Auth            string   `json:"auth,omitempty"`
+		KeyID           uint32   `json:"key_id,omitempty"`
+		Extensions      []string `json:"extensions,omitempty"`
 	} `json:"response"`
 	Config struct {
 		NMinutes int `json:"N_minutes"`
@@ -48,18 +77,129 @@ type TransactionLog struct {
 }
 
 // NewNTPServer creates a new NTP server
-func NewNTPServer(config *Config) *NTPServer {
-	return &NTPServer{
-		config:  config,
-		tracker: NewClientTimeTracker(config),
+func NewNTPServer(config *Config) (*NTPServer, error) {
+	policies, err := newServerPolicies(config, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	nts, err := NewNTS(config)
+	if err != nil {
+		return nil, err
+	}
+
+	peers, err := NewPeerTable(config)
+	if err != nil {
+		return nil, err
+	}
+
+	tracker := NewClientTimeTracker(config)
+	if config.Persistence.Enabled {
+		if _, err := tracker.LoadState(config.Persistence.Path); err != nil {
+			return nil, err
+		}
+		if config.Persistence.IntervalSeconds > 0 {
+			go tracker.persistLoop(config.Persistence.Path, time.Duration(config.Persistence.IntervalSeconds)*time.Second)
+		}
+	}
+
+	server := &NTPServer{
+		tracker:  tracker,
+		nts:      nts,
+		peers:    peers,
+		metrics:  NewMetrics(),
+		pipeline: newPipeline(config),
 	}
+	server.policies.Store(policies)
+	return server, nil
+}
+
+// newServerPolicies builds the request-handling components for a
+// configuration. A previous rate limiter is kept when its settings are
+// unchanged so per-source buckets survive a reload.
+func newServerPolicies(config *Config, previous *serverPolicies) (*serverPolicies, error) {
+	kod, err := NewKoDPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	acl, err := NewAccessList(config)
+	if err != nil {
+		return nil, err
+	}
+
+	keyer, err := NewClientKeyer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var limiter *RateLimiter
+	if previous != nil && previous.config.Security.RateLimit == config.Security.RateLimit {
+		limiter = previous.limiter
+	} else if limiter, err = NewRateLimiter(config); err != nil {
+		return nil, err
+	}
+
+	leap, err := NewLeapSimulator(config)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := NewAuthenticator(config)
+	if err != nil {
+		return nil, err
+	}
+
+	extensions, err := NewExtensionInjector(config)
+	if err != nil {
+		return nil, err
+	}
+
+	control, err := NewControlResponder(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &serverPolicies{
+		config:     config,
+		keyer:      keyer,
+		kod:        kod,
+		acl:        acl,
+		limiter:    limiter,
+		leap:       leap,
+		auth:       auth,
+		extensions: extensions,
+		control:    control,
+	}, nil
+}
+
+// admit applies the access list and rate limit to a request on one of the
+// non-NTP protocols, which cannot carry a Kiss-o'-Death. Requests that are
+// not admitted are counted and should be dropped.
+func (s *NTPServer) admit(p *serverPolicies, addr *net.UDPAddr, protocol string) bool {
+	if !p.acl.Allowed(addr.IP) {
+		LogDebug("Dropping %s request from %s: not permitted by access list", protocol, addr.String())
+		s.metrics.RecordResponse(OutcomeDropped, "access_denied")
+		return false
+	}
+	if allowed, _ := p.limiter.Allow(addr.IP.String()); !allowed && p.limiter.Action() != RateLimitActionLog {
+		s.metrics.RecordResponse(OutcomeDropped, "rate_limit")
+		return false
+	}
+	return true
+}
+
+// Config returns the configuration the server is currently using
+func (s *NTPServer) Config() *Config {
+	return s.policies.Load().config
 }
 
 // Start starts the NTP server
 func (s *NTPServer) Start() error {
+	config := s.Config()
 	addr := &net.UDPAddr{
-		IP:   net.ParseIP(s.config.Server.Host),
-		Port: s.config.Server.Port,
+		IP:   net.ParseIP(config.Server.Host),
+		Port: config.Server.Port,
 	}
 
 	conn, err := net.ListenUDP("udp", addr)
@@ -68,72 +208,183 @@ func (s *NTPServer) Start() error {
 	}
 	s.conn = conn
 
-	LogInfo("ChaosNTPd listening on %s:%d", s.config.Server.Host, s.config.Server.Port)
+	if config.Server.KernelTimestamps {
+		if err := enableRxTimestamps(conn); err != nil {
+			LogWarning("Kernel receive timestamps unavailable, using userspace timestamps: %v", err)
+		}
+	}
+
+	LogInfo("ChaosNTPd listening on %s:%d", config.Server.Host, config.Server.Port)
 	LogInfo("Stratum: %d, Initial Offset: ±%d min, Jitter: ±%d sec",
-		s.config.NTP.Stratum,
-		s.config.TimeManipulation.InitialOffsetMinutes,
-		s.config.TimeManipulation.JitterSeconds)
+		config.NTP.Stratum,
+		config.TimeManipulation.InitialOffsetMinutes,
+		config.TimeManipulation.JitterSeconds)
 
 	// Start statistics goroutine
 	go s.statsLoop()
 
-	// Handle requests
-	buffer := make([]byte, 1024)
-	for {
-		n, clientAddr, err := conn.ReadFromUDP(buffer)
-		if err != nil {
-			LogError("Error reading UDP packet: %v", err)
-			continue
-		}
-
-		// Handle in goroutine for concurrency
-		go s.handleRequest(buffer[:n], clientAddr)
+	// Poll configured symmetric peers
+	if s.peers.Enabled() && len(s.peers.addrs) > 0 {
+		go s.peerLoop()
 	}
+
+	// Handle requests on the worker pool (blocks until Stop)
+	return s.pipeline.run(conn, s.handleRequest)
 }
 
-// handleRequest handles a single NTP request
-func (s *NTPServer) handleRequest(data []byte, clientAddr *net.UDPAddr) {
-	startTime := time.Now()
+// handleRequest handles a single NTP request received at rxTime
+func (s *NTPServer) handleRequest(data []byte, clientAddr *net.UDPAddr, rxTime time.Time) {
+	// Mode 6 control queries have their own, shorter format
+	if len(data) > 0 && data[0]&0x07 == 6 {
+		s.handleControl(data, clientAddr, rxTime)
+		return
+	}
 
 	// Parse request
-	request, err := ParseNTPPacket(data)
+	p := s.policies.Load()
+	request, err := ParseNTPPacket(data, p.config.ExtensionFields.Strict)
+	if err == nil {
+		s.metrics.RecordRequest(request.Mode, request.Version)
+	}
+
+	// Enforce the access list before anything else, including the parse
+	// error log, sees the request
+	if !p.acl.Allowed(clientAddr.IP) {
+		if code := p.acl.DenyCode(); code != "" && err == nil {
+			s.sendKoD(request, clientAddr, code, "access_denied")
+		} else {
+			LogDebug("Dropping request from %s: not permitted by access list", clientAddr.String())
+			s.metrics.RecordResponse(OutcomeDropped, "access_denied")
+		}
+		return
+	}
 	if err != nil {
-		LogError("Error parsing NTP packet from %s: %v", clientAddr.String(), err)
+		LogDebug("Error parsing NTP packet from %s: %v", clientAddr.String(), err)
+		s.metrics.RecordResponse(OutcomeDropped, "malformed")
 		return
 	}
 
-	// Validate it's a client request
-	if request.Mode != 3 {
+	// Per-source rate limiting
+	if allowed, started := p.limiter.Allow(clientAddr.IP.String()); !allowed {
+		if started {
+			LogWarning("Rate limiting %s (action: %s)", clientAddr.IP.String(), p.limiter.Action())
+		}
+		switch p.limiter.Action() {
+		case RateLimitActionDrop:
+			s.metrics.RecordResponse(OutcomeDropped, "rate_limit")
+			return
+		case RateLimitActionKoD:
+			s.sendKoD(request, clientAddr, "RATE", "rate_limit")
+			return
+		}
+	}
+
+	// Answer client requests and, if enabled, symmetric peers. Passive
+	// (mode 2) packets are only expected from peers we poll.
+	symmetric := s.peers.Enabled() && (request.Mode == 1 || request.Mode == 2)
+	if request.Mode != 3 && !symmetric {
 		LogWarning("Ignoring non-client request (mode %d) from %s", request.Mode, clientAddr.String())
+		s.metrics.RecordResponse(OutcomeDropped, "unsupported_mode")
+		return
+	}
+	if request.Mode == 2 && !s.peers.IsActive(clientAddr) {
+		LogDebug("Ignoring symmetric passive packet from unconfigured peer %s", clientAddr.String())
+		s.metrics.RecordResponse(OutcomeDropped, "unknown_peer")
+		return
+	}
+
+	// Chaos Kiss-o'-Death responses bypass the tracker entirely
+	if code, reason, ok := p.kod.Decide(clientAddr.IP); ok {
+		s.sendKoD(request, clientAddr, code, reason)
+		return
+	}
+
+	// Verify NTS or MAC authentication, if any. NTS failures get an NTS NAK.
+	ntsRequest, err := s.nts.Open(data, request)
+	var nak *ntsError
+	if errors.As(err, &nak) {
+		LogDebug("NTS authentication failed for %s: %s", clientAddr.String(), nak.reason)
+		s.sendKoD(request, clientAddr, "NTSN", "nts_"+nak.reason, nak.NAKExtensions()...)
+		return
+	}
+	authResult := p.auth.Verify(data, request)
+	if ntsRequest == nil && (s.nts.Required() || authResult.status == authUnsigned && p.auth.Required()) {
+		LogDebug("Dropping unauthenticated request from %s", clientAddr.String())
+		s.metrics.RecordResponse(OutcomeDropped, "unauthenticated")
+		return
+	}
+	if authResult.status == authInvalid {
+		LogDebug("Authentication failed for %s (key %d): %s", clientAddr.String(), authResult.keyID, authResult.reason)
+	}
+
+	// Configured peers get our packets on the poll timer, which advances
+	// their timeline once per exchange; their packets only update the
+	// association
+	if symmetric && s.peers.IsActive(clientAddr) {
+		received, _ := p.leap.Apply(rxTime.Add(s.peers.Skew(clientAddr)))
+		s.peers.Observe(clientAddr, "", request, UnixToNTP(received), rxTime)
 		return
 	}
 
-	// Get manipulated time
-	clientKey := clientAddr.IP.String()
-	manipulatedTime, offset, isInitial := s.tracker.GetManipulatedTime(clientKey)
+	// Get manipulated time for the moment the packet arrived (T2)
+	clientKey := p.keyer.Key(clientAddr, request)
+	manipulatedTime, offset, isInitial := s.tracker.GetManipulatedTime(clientKey, clientAddr.IP, rxTime)
+
+	// Create response, applying any simulated leap second
+	servedTime, leapIndicator := p.leap.Apply(manipulatedTime)
+	response := CreateResponse(request, p.config, servedTime)
+	response.LeapIndicator = leapIndicator
+
+	// Unconfigured symmetric active peers are answered in passive mode
+	if symmetric {
+		if !s.peers.Observe(clientAddr, clientKey, request, response.ReceiveTime, rxTime) {
+			s.metrics.RecordResponse(OutcomeDropped, "peer_limit")
+			return
+		}
+		response.Mode = 2 // Symmetric passive
+	}
 
-	// Create response
-	response := CreateResponse(request, s.config, manipulatedTime)
+	// Stamp T3 as late as possible, shifted by the same offset as T2
+	txTime := time.Now()
+	txServed, _ := p.leap.Apply(txTime.Add(manipulatedTime.Sub(rxTime)))
+	response.TransmitTime = UnixToNTP(txServed)
+
+	// Injected extension fields follow any NTS authenticator but are
+	// covered by a symmetric-key MAC
+	var authOutcome string
+	if ntsRequest != nil {
+		authOutcome = s.nts.Seal(response, ntsRequest)
+	}
+	injected := p.extensions.Inject(response)
+	if ntsRequest == nil {
+		authOutcome = p.auth.Sign(response, authResult)
+	}
 
 	// Send response
 	responseBytes := response.ToBytes()
 	_, err = s.conn.WriteToUDP(responseBytes, clientAddr)
 	if err != nil {
 		LogError("Error sending response to %s: %v", clientAddr.String(), err)
+		s.metrics.RecordResponse(OutcomeDropped, "send_error")
 		return
 	}
 
-	processingTime := time.Since(startTime)
+	if symmetric {
+		s.peers.Sent(clientAddr, response.TransmitTime, txTime)
+	}
+
+	processingTime := txTime.Sub(rxTime)
+	s.metrics.RecordServed(offset, processingTime)
 
 	// Log transaction
-	if s.config.Logging.LogTransactions {
-		s.logTransaction(request, response, clientAddr, offset, isInitial, manipulatedTime, processingTime)
+	if p.config.Logging.LogTransactions {
+		s.logTransaction(request, response, clientAddr, offset, isInitial, manipulatedTime, processingTime, authOutcome, injected)
 	}
 }
 
 // logTransaction logs a transaction
 func (s *NTPServer) logTransaction(request, response *NTPPacket, clientAddr *net.UDPAddr,
-	offset float64, isInitial bool, manipulatedTime time.Time, processingTime time.Duration) {
+	offset float64, isInitial bool, manipulatedTime time.Time, processingTime time.Duration, authOutcome string, injected []string) {
 
 	log := TransactionLog{
 		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
@@ -160,22 +411,85 @@ func (s *NTPServer) logTransaction(request, response *NTPPacket, clientAddr *net
 	log.Response.OffsetSeconds = offset
 	log.Response.OffsetMinutes = offset / 60.0
 	log.Response.ManipulatedTime = manipulatedTime.UTC().Format(time.RFC3339Nano)
+	log.Response.Auth = authOutcome
+	if response.MAC != nil {
+		log.Response.KeyID = response.MAC.KeyID
+	}
+	log.Response.Extensions = injected
 
-	log.Config.NMinutes = s.config.TimeManipulation.InitialOffsetMinutes
-	log.Config.XSeconds = s.config.TimeManipulation.JitterSeconds
-	log.Config.Stratum = s.config.NTP.Stratum
+	manipulation := s.tracker.Config().TimeManipulation
+	log.Config.NMinutes = manipulation.InitialOffsetMinutes
+	log.Config.XSeconds = manipulation.JitterSeconds
+	log.Config.Stratum = s.Config().NTP.Stratum
 
 	log.ProcessingTimeMs = float64(processingTime.Microseconds()) / 1000.0
 
-	// Output as JSON
-	if s.config.Logging.Format == "json" {
+	text := fmt.Sprintf("%s request from %s:%d - offset: %.1f sec (%.2f min)",
+		log.RequestType, log.Client.IP, log.Client.Port,
+		log.Response.OffsetSeconds, log.Response.OffsetMinutes)
+	if authOutcome != "" {
+		text += fmt.Sprintf(" [auth: %s, key %d]", authOutcome, log.Response.KeyID)
+	}
+	if len(injected) > 0 {
+		text += fmt.Sprintf(" [extensions: %s]", strings.Join(injected, ", "))
+	}
+	s.writeTransaction(&log, text)
+}
+
+// sendKoD answers a request with a Kiss-o'-Death packet, optionally
+// carrying extension fields
+func (s *NTPServer) sendKoD(request *NTPPacket, clientAddr *net.UDPAddr, code, reason string, extensions ...ExtensionField) {
+	response := CreateKoDResponse(request, code)
+	response.Extensions = extensions
+
+	if _, err := s.conn.WriteToUDP(response.ToBytes(), clientAddr); err != nil {
+		LogError("Error sending KoD to %s: %v", clientAddr.String(), err)
+		s.metrics.RecordResponse(OutcomeDropped, "send_error")
+		return
+	}
+	s.metrics.RecordResponse(OutcomeKoD, reason)
+
+	if s.Config().Logging.LogTransactions {
+		s.logKoD(request, clientAddr, code, reason)
+	}
+}
+
+// logKoD logs a Kiss-o'-Death transaction
+func (s *NTPServer) logKoD(request *NTPPacket, clientAddr *net.UDPAddr, code, reason string) {
+	log := TransactionLog{
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Event:       "ntp_kod",
+		RequestType: "kod",
+		Reason:      reason,
+	}
+
+	log.Client.IP = clientAddr.IP.String()
+	log.Client.Port = clientAddr.Port
+
+	log.Request.Version = int(request.Version)
+	log.Request.Mode = int(request.Mode)
+	log.Request.TransmitTimestamp = NTPToUnix(request.TransmitTime).UTC().Format(time.RFC3339Nano)
+
+	log.Response.ReferenceID = code
+	log.Response.KissCode = code
+	log.Response.ActualTime = time.Now().UTC().Format(time.RFC3339Nano)
+
+	manipulation := s.tracker.Config().TimeManipulation
+	log.Config.NMinutes = manipulation.InitialOffsetMinutes
+	log.Config.XSeconds = manipulation.JitterSeconds
+	log.Config.Stratum = s.Config().NTP.Stratum
+
+	s.writeTransaction(&log, fmt.Sprintf("KoD %s sent to %s:%d (%s)",
+		code, log.Client.IP, log.Client.Port, reason))
+}
+
+// writeTransaction outputs a transaction log entry in the configured format
+func (s *NTPServer) writeTransaction(log *TransactionLog, text string) {
+	if s.Config().Logging.Format == "json" {
 		jsonData, _ := json.Marshal(log)
-		fmt.Println(string(jsonData))
+		WriteTransaction(string(jsonData))
 	} else {
-		// Text format
-		fmt.Printf("[%s] %s request from %s:%d - offset: %.1f sec (%.2f min)\n",
-			log.Timestamp, log.RequestType, log.Client.IP, log.Client.Port,
-			log.Response.OffsetSeconds, log.Response.OffsetMinutes)
+		WriteTransaction(fmt.Sprintf("[%s] %s", log.Timestamp, text))
 	}
 }
 
@@ -187,13 +501,33 @@ func (s *NTPServer) statsLoop() {
 	for range ticker.C {
 		clients, requests := s.tracker.GetStats()
 		LogInfo("Statistics: %d active clients, %d total requests served", clients, requests)
+
+		ps := s.pipeline.stats()
+		LogInfo("Pipeline: %d received, %d processed, %d dropped (queue full), %d read errors, queue %d/%d, %d workers",
+			ps.Received, ps.Processed, ps.Dropped, ps.ReadErrors, ps.QueueLen, ps.QueueCap, ps.Workers)
+
+		if p := s.policies.Load(); p.config.Security.RateLimit.Enabled {
+			rl := p.limiter.Stats()
+			LogInfo("Rate limiting: %d sources tracked, %d currently limited, %d requests limited (%d episodes, %d evictions)",
+				rl.TrackedSources, rl.LimitingSources, rl.LimitedRequests, rl.LimitedSources, rl.Evictions)
+		}
 	}
 }
 
-// Stop stops the server
+// Stop stops the server and saves client state if persistence is enabled
 func (s *NTPServer) Stop() error {
+	var err error
 	if s.conn != nil {
-		return s.conn.Close()
+		err = s.conn.Close()
 	}
-	return nil
+
+	if persistence := s.Config().Persistence; persistence.Enabled {
+		if n, saveErr := s.tracker.SaveState(persistence.Path); saveErr != nil {
+			LogError("Failed to save client state: %v", saveErr)
+		} else {
+			LogInfo("Saved %d clients to %s", n, persistence.Path)
+		}
+	}
+
+	return err
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// RFC 5297 appendix A test vectors
+func TestAESSIV(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		associated []string
+		plaintext  string
+		want       string
+	}{
+		{
+			name:       "deterministic",
+			key:        "fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff",
+			associated: []string{"10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627"},
+			plaintext:  "11223344 55667788 99aabbcc ddee",
+			want:       "85632d07 c6e8f37f 950acd32 0a2ecc93 40c02b96 90c4dc04 daef7f6a fe5c",
+		},
+		{
+			name: "nonce-based",
+			key:  "7f7e7d7c 7b7a7978 77767574 73727170 40414243 44454647 48494a4b 4c4d4e4f",
+			associated: []string{
+				"00112233 44556677 8899aabb ccddeeff deaddada deaddada ffeeddcc bbaa9988 77665544 33221100",
+				"10203040 50607080 90a0",
+				"09f91102 9d74e35b d84156c5 635688c0", // Nonce
+			},
+			plaintext: "74686973 20697320 736f6d65 20706c61 696e7465 78742074 6f20656e 63727970" +
+				"74207573 696e6720 5349562d 414553",
+			want: "7bdb6e3b 432667eb 06f4d14b ff2fbd0f cb900f2f ddbe4043 26601965 c889bf17" +
+				"dba77ceb 094fa663 b7a3f748 ba8af829 ea64ad54 4a272e9c 485b62a3 fd5c0d",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			siv, err := newAESSIV(mustHex(t, tt.key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var associated [][]byte
+			for _, ad := range tt.associated {
+				associated = append(associated, mustHex(t, ad))
+			}
+			plaintext := mustHex(t, tt.plaintext)
+
+			sealed := siv.Seal(plaintext, associated...)
+			if want := mustHex(t, tt.want); string(sealed) != string(want) {
+				t.Fatalf("Seal = %x, want %x", sealed, want)
+			}
+			opened, err := siv.Open(sealed, associated...)
+			if err != nil || string(opened) != string(plaintext) {
+				t.Fatalf("Open = %x, %v; want %x", opened, err, plaintext)
+			}
+
+			// Any change to the ciphertext, IV or associated data must fail
+			for i := range sealed {
+				tampered := append([]byte(nil), sealed...)
+				tampered[i] ^= 0x01
+				if _, err := siv.Open(tampered, associated...); !errors.Is(err, errSIVAuth) {
+					t.Fatalf("Open with byte %d flipped: err = %v, want %v", i, err, errSIVAuth)
+				}
+			}
+			if _, err := siv.Open(sealed, associated[:len(associated)-1]...); !errors.Is(err, errSIVAuth) {
+				t.Errorf("Open without the last associated data: err = %v, want %v", err, errSIVAuth)
+			}
+		})
+	}
+}
+
+func TestAESSIVShortInput(t *testing.T) {
+	siv, err := newAESSIV(make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := siv.Open(make([]byte, 15)); !errors.Is(err, errSIVAuth) {
+		t.Errorf("Open(15 bytes): err = %v, want %v", err, errSIVAuth)
+	}
+	if _, err := newAESSIV(make([]byte, 16)); err == nil {
+		t.Error("newAESSIV accepted a 16-byte key")
+	}
+
+	// An empty plaintext still round-trips through the padded S2V branch
+	sealed := siv.Seal(nil, []byte("ad"))
+	if opened, err := siv.Open(sealed, []byte("ad")); err != nil || len(opened) != 0 {
+		t.Errorf("Open(Seal(empty)) = %x, %v", opened, err)
+	}
+}
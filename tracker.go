@@ -1,17 +1,51 @@
 package main
 
 import (
+	"fmt"
+	"math"
 	"math/rand"
+	"net"
+	"sort"
 	"sync"
 	"time"
 )
 
 // ClientState tracks the time state for a client
 type ClientState struct {
-	LastManipulatedTime time.Time
-	LastActualTime      time.Time
-	FirstSeen           time.Time
-	RequestCount        int
+	LastManipulatedTime time.Time `json:"last_manipulated_time"`
+	LastActualTime      time.Time `json:"last_actual_time"`
+	FirstSeen           time.Time `json:"first_seen"`
+	RequestCount        int       `json:"request_count"`
+	Address             string    `json:"address,omitempty"` // Source address of the latest request
+	Profile             string    `json:"profile,omitempty"` // Manipulation profile picked on first contact
+	FrequencyPPM        float64   `json:"frequency_ppm"`     // Simulated oscillator frequency error
+	Phase               string    `json:"phase,omitempty"`   // Current scenario phase, if a scenario is running
+	PhaseSeq            int       `json:"phase_seq"`         // Sequence number of the current scenario phase
+	Frozen              bool      `json:"frozen,omitempty"`  // Clock held still by the admin API
+}
+
+// IP returns the client's last source address, or nil if none is recorded
+func (s *ClientState) IP() net.IP {
+	if s.Address != "" {
+		return net.ParseIP(s.Address)
+	}
+	return nil
+}
+
+// ClientSnapshot is a point-in-time copy of a client's state
+type ClientSnapshot struct {
+	Key                  string    `json:"key"`
+	Address              string    `json:"address,omitempty"`
+	Profile              string    `json:"profile,omitempty"`
+	FirstSeen            time.Time `json:"first_seen"`
+	LastActualTime       time.Time `json:"last_actual_time"`
+	LastManipulatedTime  time.Time `json:"last_manipulated_time"`
+	RequestCount         int       `json:"request_count"`
+	FrequencyPPM         float64   `json:"frequency_ppm"`
+	Phase                string    `json:"phase,omitempty"`
+	Frozen               bool      `json:"frozen"`
+	LastOffsetSeconds    float64   `json:"last_offset_seconds"`
+	CurrentOffsetSeconds float64   `json:"current_offset_seconds"`
 }
 
 // ClientTimeTracker tracks manipulated time for each client
@@ -20,14 +54,48 @@ type ClientTimeTracker struct {
 	clientStates map[string]*ClientState
 	config       *Config
 	rand         *rand.Rand
+	dist         Distribution
+	profiles     *ProfileSet
+	scenario     *Scenario
+	scenarioSeq  int
+	startTime    time.Time
+
+	staleEvictions uint64
+	limitEvictions uint64
+}
+
+// TrackerStats is a snapshot of tracker counters
+type TrackerStats struct {
+	Clients        int
+	StaleEvictions uint64
+	LimitEvictions uint64
 }
 
 // NewClientTimeTracker creates a new client time tracker
 func NewClientTimeTracker(config *Config) *ClientTimeTracker {
+	dist, err := NewDistribution(config.TimeManipulation.Distribution, config.TimeManipulation.DistributionParams)
+	if err != nil {
+		LogWarning("Invalid distribution (%v), falling back to uniform", err)
+		dist = uniformDistribution{}
+	}
+
+	profiles, err := NewProfileSet(config)
+	if err != nil {
+		LogWarning("Invalid profiles (%v), using default manipulation for all clients", err)
+		profiles = &ProfileSet{defaults: defaultManipulation(config)}
+	}
+
 	tracker := &ClientTimeTracker{
 		clientStates: make(map[string]*ClientState),
 		config:       config,
 		rand:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		dist:         dist,
+		profiles:     profiles,
+		scenarioSeq:  -1,
+		startTime:    time.Now(),
+	}
+	if config.TimeManipulation.Scenario.Enabled() {
+		tracker.scenario = &config.TimeManipulation.Scenario
 	}
 
 	// Start cleanup goroutine
@@ -36,18 +104,40 @@ func NewClientTimeTracker(config *Config) *ClientTimeTracker {
 	return tracker
 }
 
-// GetManipulatedTime returns the manipulated time for a client
-func (t *ClientTimeTracker) GetManipulatedTime(clientAddr string) (time.Time, float64, bool) {
+// GetManipulatedTime returns the manipulated time for a client at the given
+// actual time, usually the moment its request arrived. The client key
+// identifies the timeline; address is the request's source IP.
+func (t *ClientTimeTracker) GetManipulatedTime(clientAddr string, address net.IP, actualTime time.Time) (time.Time, float64, bool) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	actualTime := time.Now()
 	state, exists := t.clientStates[clientAddr]
+	if exists && address != nil {
+		state.Address = address.String()
+	}
+
+	// Workers may finish a client's packets out of arrival order; never
+	// run its clock backwards
+	if exists && actualTime.Before(state.LastActualTime) {
+		actualTime = state.LastActualTime
+	}
+
+	// Clients frozen through the admin API keep serving the same time
+	if exists && state.Frozen {
+		state.LastActualTime = actualTime
+		state.RequestCount++
+		return state.LastManipulatedTime, state.LastManipulatedTime.Sub(actualTime).Seconds(), false
+	}
+
+	// Honest profiles opt clients out of scenarios too
+	if t.scenario != nil && !t.profileFor(clientAddr, address, state).honest {
+		return t.getScenarioTime(clientAddr, address, state, exists, actualTime)
+	}
 
 	if !exists {
 		// Initial request - apply large offset
-		offsetMinutes := t.config.TimeManipulation.InitialOffsetMinutes
-		offsetSeconds := t.randomFloat(-float64(offsetMinutes*60), float64(offsetMinutes*60))
+		profile := t.profiles.Match(clientAddr, address)
+		offsetSeconds := t.initialOffset(profile)
 		manipulatedTime := actualTime.Add(time.Duration(offsetSeconds * float64(time.Second)))
 
 		// Store state
@@ -56,17 +146,20 @@ func (t *ClientTimeTracker) GetManipulatedTime(clientAddr string) (time.Time, fl
 			LastActualTime:      actualTime,
 			FirstSeen:           actualTime,
 			RequestCount:        1,
+			Address:             sourceAddress(address),
+			Profile:             profile.name,
+			FrequencyPPM:        t.initialFrequency(profile),
 		}
 
 		return manipulatedTime, offsetSeconds, true // true = initial request
 	}
 
-	// Subsequent request - apply jitter
+	// Subsequent request - advance the client's simulated oscillator, then apply jitter
 	elapsed := actualTime.Sub(state.LastActualTime)
-	expectedTime := state.LastManipulatedTime.Add(elapsed)
+	t.wanderFrequency(state, elapsed)
+	expectedTime := state.LastManipulatedTime.Add(scaleByFrequency(elapsed, state.FrequencyPPM))
 
-	jitterSeconds := t.config.TimeManipulation.JitterSeconds
-	jitter := t.randomFloat(-float64(jitterSeconds), float64(jitterSeconds))
+	jitter := t.sample(t.profiles.Get(state.Profile).jitterBound)
 	manipulatedTime := expectedTime.Add(time.Duration(jitter * float64(time.Second)))
 
 	// Update state
@@ -80,9 +173,92 @@ func (t *ClientTimeTracker) GetManipulatedTime(clientAddr string) (time.Time, fl
 	return manipulatedTime, offset, false // false = subsequent request
 }
 
-// randomFloat generates a random float between min and max
-func (t *ClientTimeTracker) randomFloat(min, max float64) float64 {
-	return min + t.rand.Float64()*(max-min)
+// sourceAddress formats a request's source address; broadcasts have none
+func sourceAddress(address net.IP) string {
+	if address == nil {
+		return ""
+	}
+	return address.String()
+}
+
+// getScenarioTime returns the manipulated time for a client following the
+// configured scenario timeline. The caller must hold t.mu.
+func (t *ClientTimeTracker) getScenarioTime(clientAddr string, address net.IP, state *ClientState, exists bool, actualTime time.Time) (time.Time, float64, bool) {
+	if !exists {
+		profile := t.profiles.Match(clientAddr, address)
+		state = &ClientState{
+			LastManipulatedTime: actualTime,
+			LastActualTime:      actualTime,
+			FirstSeen:           actualTime,
+			Address:             sourceAddress(address),
+			Profile:             profile.name,
+			FrequencyPPM:        t.initialFrequency(profile),
+			PhaseSeq:            -1,
+		}
+		t.clientStates[clientAddr] = state
+	}
+
+	t.advanceScenario(state, actualTime)
+
+	if exists {
+		_, index, _ := t.scenario.phaseAt(actualTime.Sub(t.scenarioOrigin(state)))
+		jitter := t.sample(t.phaseJitter(state, t.scenario.Phases[index]))
+		state.LastManipulatedTime = state.LastManipulatedTime.Add(secondsToDuration(jitter))
+	}
+	state.RequestCount++
+
+	offset := state.LastManipulatedTime.Sub(actualTime).Seconds()
+
+	return state.LastManipulatedTime, offset, !exists
+}
+
+// sample draws a value from the configured distribution scaled to ±bound
+func (t *ClientTimeTracker) sample(bound float64) float64 {
+	return bound * t.dist.Sample(t.rand)
+}
+
+// profileFor returns the manipulation settings for a client, picking a
+// profile for clients not yet tracked
+func (t *ClientTimeTracker) profileFor(key string, address net.IP, state *ClientState) *manipulation {
+	if state != nil {
+		return t.profiles.Get(state.Profile)
+	}
+	return t.profiles.Match(key, address)
+}
+
+// initialOffset picks a new client's offset from real time in seconds
+func (t *ClientTimeTracker) initialOffset(profile *manipulation) float64 {
+	if profile.fixedOffset != nil {
+		return *profile.fixedOffset
+	}
+	return t.sample(profile.offsetBound)
+}
+
+// initialFrequency picks a new client's oscillator frequency error in PPM
+func (t *ClientTimeTracker) initialFrequency(profile *manipulation) float64 {
+	if !profile.driftEnabled {
+		return 0
+	}
+	return profile.minPPM + t.rand.Float64()*(profile.maxPPM-profile.minPPM)
+}
+
+// wanderFrequency applies a random walk to the client's frequency error,
+// scaled so the step deviation is WanderPPM per square root of an hour
+func (t *ClientTimeTracker) wanderFrequency(state *ClientState, elapsed time.Duration) {
+	profile := t.profiles.Get(state.Profile)
+	if !profile.driftEnabled || profile.wanderPPM <= 0 || elapsed <= 0 {
+		return
+	}
+	state.FrequencyPPM += profile.wanderPPM * math.Sqrt(elapsed.Hours()) * t.rand.NormFloat64()
+}
+
+// scaleByFrequency converts real elapsed time into elapsed time on a clock
+// running fast or slow by ppm parts per million
+func scaleByFrequency(elapsed time.Duration, ppm float64) time.Duration {
+	if ppm == 0 {
+		return elapsed
+	}
+	return time.Duration(float64(elapsed) * (1 + ppm/1e6))
 }
 
 // GetStats returns statistics about tracked clients
@@ -99,9 +275,193 @@ func (t *ClientTimeTracker) GetStats() (int, int) {
 	return totalClients, totalRequests
 }
 
+// Stats returns a snapshot of the tracker counters
+func (t *ClientTimeTracker) Stats() TrackerStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return TrackerStats{
+		Clients:        len(t.clientStates),
+		StaleEvictions: t.staleEvictions,
+		LimitEvictions: t.limitEvictions,
+	}
+}
+
+// Config returns the configuration the tracker is currently using
+func (t *ClientTimeTracker) Config() *Config {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.config
+}
+
+// UpdateConfig switches the tracker to a new, already validated
+// configuration without discarding client state
+func (t *ClientTimeTracker) UpdateConfig(config *Config) {
+	dist, err := NewDistribution(config.TimeManipulation.Distribution, config.TimeManipulation.DistributionParams)
+	if err != nil {
+		LogWarning("Invalid distribution (%v), keeping previous configuration", err)
+		return
+	}
+	profiles, err := NewProfileSet(config)
+	if err != nil {
+		LogWarning("Invalid profiles (%v), keeping previous configuration", err)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.config = config
+	t.dist = dist
+	t.profiles = profiles
+	t.scenario = nil
+	if config.TimeManipulation.Scenario.Enabled() {
+		t.scenario = &config.TimeManipulation.Scenario
+	}
+}
+
+// Clients returns snapshots of all tracked clients
+func (t *ClientTimeTracker) Clients() []ClientSnapshot {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	now := time.Now()
+	snapshots := make([]ClientSnapshot, 0, len(t.clientStates))
+	for key, state := range t.clientStates {
+		snapshots = append(snapshots, snapshotClient(key, state, now))
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Key < snapshots[j].Key })
+	return snapshots
+}
+
+// Client returns a snapshot of one tracked client
+func (t *ClientTimeTracker) Client(key string) (ClientSnapshot, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	state, exists := t.clientStates[key]
+	if !exists {
+		return ClientSnapshot{}, false
+	}
+	return snapshotClient(key, state, time.Now()), true
+}
+
+// ProjectedTime returns a client's manipulated time at now without
+// advancing or creating its state; unknown clients get the real time
+func (t *ClientTimeTracker) ProjectedTime(key string, now time.Time) time.Time {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	state, exists := t.clientStates[key]
+	if !exists {
+		return now
+	}
+	return projectClock(state, now)
+}
+
+// snapshotClient copies a client's state and projects its offset to now
+func snapshotClient(key string, state *ClientState, now time.Time) ClientSnapshot {
+	return ClientSnapshot{
+		Key:                  key,
+		Address:              state.Address,
+		Profile:              state.Profile,
+		FirstSeen:            state.FirstSeen,
+		LastActualTime:       state.LastActualTime,
+		LastManipulatedTime:  state.LastManipulatedTime,
+		RequestCount:         state.RequestCount,
+		FrequencyPPM:         state.FrequencyPPM,
+		Phase:                state.Phase,
+		Frozen:               state.Frozen,
+		LastOffsetSeconds:    state.LastManipulatedTime.Sub(state.LastActualTime).Seconds(),
+		CurrentOffsetSeconds: projectClock(state, now).Sub(now).Seconds(),
+	}
+}
+
+// projectClock estimates a client's manipulated time at now without
+// advancing its state
+func projectClock(state *ClientState, now time.Time) time.Time {
+	if state.Frozen {
+		return state.LastManipulatedTime
+	}
+	return state.LastManipulatedTime.Add(scaleByFrequency(now.Sub(state.LastActualTime), state.FrequencyPPM))
+}
+
+// Client control actions for the admin API
+const (
+	ControlOffset   = "offset"   // Set the offset from real time
+	ControlStep     = "step"     // Step the clock by a number of seconds
+	ControlFreeze   = "freeze"   // Hold the clock still
+	ControlUnfreeze = "unfreeze" // Resume a frozen clock from where it stopped
+	ControlReset    = "reset"    // Forget the client; its next request is initial
+)
+
+// ControlClients applies an admin action to the clients match selects by
+// key and last source address, and returns how many changed. An offset
+// for an unknown seedKey creates its state so its first request uses it.
+func (t *ClientTimeTracker) ControlClients(match func(key string, address net.IP) bool, seedKey string, action string, seconds float64) (int, error) {
+	switch action {
+	case ControlOffset, ControlStep, ControlFreeze, ControlUnfreeze, ControlReset:
+	default:
+		return 0, fmt.Errorf("unknown action %q", action)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+
+	if seedKey != "" && action == ControlOffset {
+		if _, exists := t.clientStates[seedKey]; !exists {
+			profile := t.profiles.Match(seedKey, nil)
+			state := &ClientState{
+				LastManipulatedTime: now,
+				LastActualTime:      now,
+				FirstSeen:           now,
+				Profile:             profile.name,
+				FrequencyPPM:        t.initialFrequency(profile),
+				PhaseSeq:            -1,
+			}
+			if t.scenario != nil {
+				t.advanceScenario(state, now)
+			}
+			t.clientStates[seedKey] = state
+		}
+	}
+
+	changed := 0
+	for key, state := range t.clientStates {
+		if !match(key, state.IP()) {
+			continue
+		}
+
+		switch action {
+		case ControlOffset:
+			state.LastManipulatedTime = now.Add(secondsToDuration(seconds))
+			state.LastActualTime = now
+		case ControlStep:
+			state.LastManipulatedTime = projectClock(state, now).Add(secondsToDuration(seconds))
+			state.LastActualTime = now
+		case ControlFreeze:
+			state.LastManipulatedTime = projectClock(state, now)
+			state.LastActualTime = now
+			state.Frozen = true
+		case ControlUnfreeze:
+			state.LastActualTime = now
+			state.Frozen = false
+		case ControlReset:
+			delete(t.clientStates, key)
+		}
+		changed++
+	}
+
+	return changed, nil
+}
+
 // cleanupLoop periodically removes stale clients
 func (t *ClientTimeTracker) cleanupLoop() {
-	interval := time.Duration(t.config.TimeManipulation.ClientTracking.CleanupIntervalSeconds) * time.Second
+	interval := time.Duration(t.Config().TimeManipulation.ClientTracking.CleanupIntervalSeconds) * time.Second
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -126,6 +486,7 @@ func (t *ClientTimeTracker) cleanup() {
 		}
 	}
 
+	t.staleEvictions += uint64(staleCount)
 	if staleCount > 0 {
 		LogInfo("Cleaned up %d stale clients, %d remaining", staleCount, len(t.clientStates))
 	}
@@ -150,6 +511,7 @@ func (t *ClientTimeTracker) cleanup() {
 			delete(t.clientStates, clients[i].addr)
 		}
 
+		t.limitEvictions += uint64(removeCount)
 		LogWarning("Enforced max clients limit, removed %d oldest clients", removeCount)
 	}
 }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProjectedTimeLeavesStateAlone(t *testing.T) {
+	config := &Config{}
+	config.TimeManipulation.InitialOffsetMinutes = 30
+	config.TimeManipulation.ClientTracking.CleanupIntervalSeconds = 300
+	tracker := NewClientTimeTracker(config)
+
+	now := time.Now()
+	if got := tracker.ProjectedTime("192.0.2.1", now); !got.Equal(now) {
+		t.Errorf("unknown client projected to %v, want the real time %v", got, now)
+	}
+	if clients, _ := tracker.GetStats(); clients != 0 {
+		t.Fatalf("projection created %d clients", clients)
+	}
+
+	served, _, _ := tracker.GetManipulatedTime("192.0.2.1", net.ParseIP("192.0.2.1"), now)
+	later := now.Add(10 * time.Second)
+	if got := tracker.ProjectedTime("192.0.2.1", later); !got.Equal(served.Add(10 * time.Second)) {
+		t.Errorf("projected %v, want %v", got, served.Add(10*time.Second))
+	}
+	snapshot, _ := tracker.Client("192.0.2.1")
+	if snapshot.RequestCount != 1 || !snapshot.LastActualTime.Equal(now) {
+		t.Errorf("projection advanced the client: %+v", snapshot)
+	}
+}